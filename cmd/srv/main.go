@@ -1,30 +1,185 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // the forecast timezone must load even without system zoneinfo
 
 	"srv.exe.dev/srv"
 )
 
-var flagListenAddr = flag.String("listen", ":8000", "address to listen on")
-
 func main() {
-	if err := run(); err != nil {
+	err := run()
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
 		fmt.Fprintln(os.Stderr, err)
 	}
+	os.Exit(exitCode(err))
+}
+
+// usageError is a bad flag or environment variable, as opposed to a
+// failure starting or running the server.
+type usageError struct{ error }
+
+func (e usageError) Unwrap() error { return e.error }
+
+// exitCode maps run's error to the process exit status: 0 on success or
+// -help, 2 for usage errors as the flag package does, and 1 otherwise, so
+// systemd's Restart=on-failure and scripts see the failure.
+func exitCode(err error) int {
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.As(err, new(usageError)):
+		return 2
+	default:
+		return 1
+	}
 }
 
 func run() error {
-	flag.Parse()
-	hostname, err := os.Hostname()
+	cfg, err := parseConfig(os.Args[1:], os.Getenv)
 	if err != nil {
-		hostname = "unknown"
+		return usageError{err}
+	}
+	slog.SetDefault(newLogger(os.Stderr, cfg))
+	c := srv.DefaultConfig()
+	c.DBPath = cfg.dbPath
+	c.Hostname = cfg.hostname
+	if cfg.hasLocation {
+		c.LocationName, c.Lat, c.Lon = cfg.locationName, cfg.lat, cfg.lon
+	}
+	if cfg.timezone != "" {
+		c.Timezone = cfg.timezone
 	}
-	server, err := srv.New("db.sqlite3", hostname)
+	if cfg.fetchTimeout != 0 {
+		c.FetchTimeout = cfg.fetchTimeout
+	}
+	c.DevMode = cfg.dev
+	c.TemplatesDir = cfg.templatesDir
+	c.StaticDir = cfg.staticDir
+	c.APIToken = cfg.apiToken
+	if cfg.userAgent != "" {
+		c.UserAgent = cfg.userAgent
+	}
+	if cfg.allowedOrigins != "" {
+		c.AllowedOrigins = strings.Split(cfg.allowedOrigins, ",")
+	}
+	server, err := srv.NewWithConfig(c)
 	if err != nil {
 		return fmt.Errorf("create server: %w", err)
 	}
-	return server.Serve(*flagListenAddr)
+	return server.Serve(cfg.addr)
+}
+
+// newLogger returns the logger configured by cfg, writing to w. Records
+// logged during a request carry its request ID.
+func newLogger(w io.Writer, cfg config) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.logLevel}
+	var h slog.Handler = slog.NewTextHandler(w, opts)
+	if cfg.logJSON {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(srv.NewLogHandler(h))
+}
+
+// config holds the command-line settings for the server.
+type config struct {
+	addr           string
+	dbPath         string
+	hostname       string
+	locationName   string
+	lat            float64
+	lon            float64
+	hasLocation    bool
+	timezone       string
+	allowedOrigins string
+	fetchTimeout   time.Duration
+	apiToken       string
+	userAgent      string
+	dev            bool
+	templatesDir   string
+	staticDir      string
+	logLevel       slog.Level
+	logJSON        bool
+}
+
+// parseConfig reads settings from args, falling back to WEATHER_*
+// environment variables (looked up with getenv) for anything not given on
+// the command line.
+func parseConfig(args []string, getenv func(string) string) (config, error) {
+	envOr := func(key, fallback string) string {
+		if v := getenv(key); v != "" {
+			return v
+		}
+		return fallback
+	}
+
+	var cfg config
+	var lat, lon string
+	fs := flag.NewFlagSet("srv", flag.ContinueOnError)
+	fs.StringVar(&cfg.addr, "addr", envOr("WEATHER_ADDR", ":8000"), "address to listen on (env WEATHER_ADDR)")
+	fs.StringVar(&cfg.addr, "listen", cfg.addr, "deprecated alias for -addr")
+	fs.StringVar(&cfg.dbPath, "db", envOr("WEATHER_DB", "db.sqlite3"), "path to the sqlite database (env WEATHER_DB)")
+	fs.StringVar(&cfg.hostname, "hostname", getenv("WEATHER_HOSTNAME"), "hostname shown on the page; defaults to the system hostname (env WEATHER_HOSTNAME)")
+	fs.StringVar(&cfg.locationName, "location", getenv("WEATHER_LOCATION"), "display name for -lat/-lon (env WEATHER_LOCATION)")
+	fs.StringVar(&lat, "lat", getenv("WEATHER_LAT"), "latitude to report weather for; defaults to Brooklyn (env WEATHER_LAT)")
+	fs.StringVar(&lon, "lon", getenv("WEATHER_LON"), "longitude to report weather for; defaults to Brooklyn (env WEATHER_LON)")
+	fs.StringVar(&cfg.timezone, "timezone", getenv("WEATHER_TIMEZONE"), "IANA timezone to show forecast times in; defaults to America/New_York (env WEATHER_TIMEZONE)")
+	fs.StringVar(&cfg.allowedOrigins, "allowed-origins", getenv("WEATHER_ALLOWED_ORIGINS"), "comma-separated origins allowed to call the API from a browser; defaults to any (env WEATHER_ALLOWED_ORIGINS)")
+	fs.StringVar(&cfg.apiToken, "api-token", getenv("WEATHER_API_TOKEN"), "bearer token required by the JSON API; the API is open if empty (env WEATHER_API_TOKEN)")
+	fs.StringVar(&cfg.userAgent, "user-agent", getenv("WEATHER_USER_AGENT"), "User-Agent sent to Open-Meteo; defaults to the app name and version (env WEATHER_USER_AGENT)")
+	fs.BoolVar(&cfg.dev, "dev", getenv("WEATHER_DEV") != "", "development mode: read templates and static assets from the source tree and have browsers revalidate them on every load (env WEATHER_DEV)")
+	fs.StringVar(&cfg.templatesDir, "templates-dir", getenv("WEATHER_TEMPLATES_DIR"), "directory to load HTML templates from; defaults to the copies built into the binary (env WEATHER_TEMPLATES_DIR)")
+	fs.StringVar(&cfg.staticDir, "static-dir", getenv("WEATHER_STATIC_DIR"), "directory to serve static assets from; defaults to the copies built into the binary (env WEATHER_STATIC_DIR)")
+	logLevel := fs.String("log-level", getenv("WEATHER_LOG_LEVEL"), "minimum log level: debug, info, warn or error; defaults to info (env WEATHER_LOG_LEVEL)")
+	logFormat := fs.String("log-format", getenv("WEATHER_LOG_FORMAT"), "log output format: text or json; defaults to text (env WEATHER_LOG_FORMAT)")
+	fetchTimeout := fs.String("fetch-timeout", getenv("WEATHER_FETCH_TIMEOUT"), "timeout for each Open-Meteo request, e.g. 30s; defaults to 10s (env WEATHER_FETCH_TIMEOUT)")
+	if err := fs.Parse(args); err != nil {
+		return config{}, err
+	}
+	if *logLevel != "" {
+		if err := cfg.logLevel.UnmarshalText([]byte(*logLevel)); err != nil {
+			return config{}, fmt.Errorf("invalid log level %q", *logLevel)
+		}
+	}
+	switch *logFormat {
+	case "", "text":
+	case "json":
+		cfg.logJSON = true
+	default:
+		return config{}, fmt.Errorf("invalid log format %q", *logFormat)
+	}
+	if *fetchTimeout != "" {
+		d, err := time.ParseDuration(*fetchTimeout)
+		if err != nil || d <= 0 {
+			return config{}, fmt.Errorf("invalid fetch timeout %q", *fetchTimeout)
+		}
+		cfg.fetchTimeout = d
+	}
+
+	if lat == "" && lon == "" {
+		return cfg, nil
+	}
+	if lat == "" || lon == "" {
+		return config{}, fmt.Errorf("-lat and -lon must be set together")
+	}
+	var err error
+	if cfg.lat, err = strconv.ParseFloat(lat, 64); err != nil {
+		return config{}, fmt.Errorf("invalid latitude %q: %w", lat, err)
+	}
+	if cfg.lon, err = strconv.ParseFloat(lon, 64); err != nil {
+		return config{}, fmt.Errorf("invalid longitude %q: %w", lon, err)
+	}
+	if cfg.locationName == "" {
+		cfg.locationName = fmt.Sprintf("%.4f, %.4f", cfg.lat, cfg.lon)
+	}
+	cfg.hasLocation = true
+	return cfg, nil
 }
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		expected config
+		wantErr  bool
+	}{
+		{
+			name:     "defaults",
+			expected: config{addr: ":8000", dbPath: "db.sqlite3"},
+		},
+		{
+			name: "flags",
+			args: []string{"-addr", ":9000", "-db", "/tmp/w.db", "-hostname", "box", "-lat", "48.8566", "-lon", "2.3522", "-location", "Paris", "-timezone", "Europe/Paris"},
+			expected: config{
+				addr: ":9000", dbPath: "/tmp/w.db", hostname: "box",
+				locationName: "Paris", lat: 48.8566, lon: 2.3522, hasLocation: true,
+				timezone: "Europe/Paris",
+			},
+		},
+		{
+			name: "environment fallback",
+			env:  map[string]string{"WEATHER_ADDR": ":7000", "WEATHER_DB": "env.db", "WEATHER_LAT": "51.5", "WEATHER_LON": "-0.12"},
+			expected: config{
+				addr: ":7000", dbPath: "env.db",
+				locationName: "51.5000, -0.1200", lat: 51.5, lon: -0.12, hasLocation: true,
+			},
+		},
+		{
+			name:     "flags override environment",
+			args:     []string{"-addr", ":9000"},
+			env:      map[string]string{"WEATHER_ADDR": ":7000"},
+			expected: config{addr: ":9000", dbPath: "db.sqlite3"},
+		},
+		{
+			name:     "deprecated listen flag",
+			args:     []string{"-listen", ":8080"},
+			expected: config{addr: ":8080", dbPath: "db.sqlite3"},
+		},
+		{
+			name:     "allowed origins",
+			env:      map[string]string{"WEATHER_ALLOWED_ORIGINS": "https://a.example,https://b.example"},
+			expected: config{addr: ":8000", dbPath: "db.sqlite3", allowedOrigins: "https://a.example,https://b.example"},
+		},
+		{
+			name:     "fetch timeout",
+			env:      map[string]string{"WEATHER_FETCH_TIMEOUT": "30s"},
+			expected: config{addr: ":8000", dbPath: "db.sqlite3", fetchTimeout: 30 * time.Second},
+		},
+		{
+			name:     "api token",
+			env:      map[string]string{"WEATHER_API_TOKEN": "s3cret"},
+			expected: config{addr: ":8000", dbPath: "db.sqlite3", apiToken: "s3cret"},
+		},
+		{
+			name:     "user agent",
+			args:     []string{"-user-agent", "weather-box/2 (ops@example.com)"},
+			expected: config{addr: ":8000", dbPath: "db.sqlite3", userAgent: "weather-box/2 (ops@example.com)"},
+		},
+		{
+			name:     "dev mode",
+			args:     []string{"-dev"},
+			expected: config{addr: ":8000", dbPath: "db.sqlite3", dev: true},
+		},
+		{
+			name:     "asset directories",
+			args:     []string{"-templates-dir", "/srv/templates"},
+			env:      map[string]string{"WEATHER_STATIC_DIR": "/srv/static"},
+			expected: config{addr: ":8000", dbPath: "db.sqlite3", templatesDir: "/srv/templates", staticDir: "/srv/static"},
+		},
+		{
+			name:     "log settings",
+			args:     []string{"-log-level", "debug"},
+			env:      map[string]string{"WEATHER_LOG_FORMAT": "json"},
+			expected: config{addr: ":8000", dbPath: "db.sqlite3", logLevel: slog.LevelDebug, logJSON: true},
+		},
+		{
+			name:    "unknown log level",
+			args:    []string{"-log-level", "loud"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown log format",
+			env:     map[string]string{"WEATHER_LOG_FORMAT": "xml"},
+			wantErr: true,
+		},
+		{
+			name:    "malformed fetch timeout",
+			args:    []string{"-fetch-timeout", "soon"},
+			wantErr: true,
+		},
+		{
+			name:    "lat without lon",
+			args:    []string{"-lat", "40"},
+			wantErr: true,
+		},
+		{
+			name:    "malformed lat",
+			args:    []string{"-lat", "north", "-lon", "0"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := parseConfig(test.args, env(test.env))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseConfig error = %v, wantErr %v", err, test.wantErr)
+			}
+			if cfg != test.expected {
+				t.Errorf("parseConfig = %+v, expected %+v", cfg, test.expected)
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected int
+	}{
+		{nil, 0},
+		{usageError{flag.ErrHelp}, 0},
+		{usageError{errors.New("invalid log level")}, 2},
+		{errors.New("listen tcp: address in use"), 1},
+	}
+	for _, test := range tests {
+		if got := exitCode(test.err); got != test.expected {
+			t.Errorf("exitCode(%v) = %d, expected %d", test.err, got, test.expected)
+		}
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, config{logLevel: slog.LevelWarn, logJSON: true})
+	logger.Info("dropped")
+	logger.Warn("kept", "n", 1)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected only the warning to be logged, got %q", buf.String())
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("expected JSON output, got %q: %v", lines[0], err)
+	}
+	if entry["msg"] != "kept" || entry["level"] != "WARN" {
+		t.Errorf("unexpected entry %v", entry)
+	}
+
+	buf.Reset()
+	newLogger(&buf, config{}).Info("hello")
+	if !strings.HasPrefix(buf.String(), "time=") {
+		t.Errorf("expected text output by default, got %q", buf.String())
+	}
+}
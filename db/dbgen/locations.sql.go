@@ -0,0 +1,102 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: locations.sql
+
+package dbgen
+
+import (
+	"context"
+	"time"
+)
+
+const addLocation = `-- name: AddLocation :one
+INSERT INTO
+  locations (name, latitude, longitude, created_at)
+VALUES
+  (?, ?, ?, ?) ON CONFLICT (name) DO NOTHING
+RETURNING
+  name, latitude, longitude, created_at
+`
+
+type AddLocationParams struct {
+	Name      string    `json:"name"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (q *Queries) AddLocation(ctx context.Context, arg AddLocationParams) (Location, error) {
+	row := q.db.QueryRowContext(ctx, addLocation,
+		arg.Name,
+		arg.Latitude,
+		arg.Longitude,
+		arg.CreatedAt,
+	)
+	var i Location
+	err := row.Scan(
+		&i.Name,
+		&i.Latitude,
+		&i.Longitude,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const listLocations = `-- name: ListLocations :many
+SELECT
+  name, latitude, longitude, created_at
+FROM
+  locations
+ORDER BY
+  name
+`
+
+func (q *Queries) ListLocations(ctx context.Context) ([]Location, error) {
+	rows, err := q.db.QueryContext(ctx, listLocations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Location{}
+	for rows.Next() {
+		var i Location
+		if err := rows.Scan(
+			&i.Name,
+			&i.Latitude,
+			&i.Longitude,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const locationWithName = `-- name: LocationWithName :one
+SELECT
+  name, latitude, longitude, created_at
+FROM
+  locations
+WHERE
+  name = ?
+`
+
+func (q *Queries) LocationWithName(ctx context.Context, name string) (Location, error) {
+	row := q.db.QueryRowContext(ctx, locationWithName, name)
+	var i Location
+	err := row.Scan(
+		&i.Name,
+		&i.Latitude,
+		&i.Longitude,
+		&i.CreatedAt,
+	)
+	return i, err
+}
@@ -8,12 +8,36 @@ import (
 	"time"
 )
 
+type Location struct {
+	Name      string    `json:"name"`
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 type Migration struct {
 	MigrationNumber int64     `json:"migration_number"`
 	MigrationName   string    `json:"migration_name"`
 	ExecutedAt      time.Time `json:"executed_at"`
 }
 
+type Observation struct {
+	ID            int64     `json:"id"`
+	RecordedAt    time.Time `json:"recorded_at"`
+	Latitude      float64   `json:"latitude"`
+	Longitude     float64   `json:"longitude"`
+	Units         string    `json:"units"`
+	Temperature   float64   `json:"temperature"`
+	FeelsLike     float64   `json:"feels_like"`
+	Humidity      int64     `json:"humidity"`
+	WindSpeed     float64   `json:"wind_speed"`
+	WindDirection int64     `json:"wind_direction"`
+	WeatherCode   int64     `json:"weather_code"`
+	Precipitation float64   `json:"precipitation"`
+	CloudCover    int64     `json:"cloud_cover"`
+	IsDay         bool      `json:"is_day"`
+}
+
 type Visitor struct {
 	ID        string    `json:"id"`
 	ViewCount int64     `json:"view_count"`
@@ -0,0 +1,123 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.30.0
+// source: observations.sql
+
+package dbgen
+
+import (
+	"context"
+	"time"
+)
+
+const insertObservation = `-- name: InsertObservation :exec
+INSERT INTO
+  observations (
+    recorded_at,
+    latitude,
+    longitude,
+    units,
+    temperature,
+    feels_like,
+    humidity,
+    wind_speed,
+    wind_direction,
+    weather_code,
+    precipitation,
+    cloud_cover,
+    is_day
+  )
+VALUES
+  (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+type InsertObservationParams struct {
+	RecordedAt    time.Time `json:"recorded_at"`
+	Latitude      float64   `json:"latitude"`
+	Longitude     float64   `json:"longitude"`
+	Units         string    `json:"units"`
+	Temperature   float64   `json:"temperature"`
+	FeelsLike     float64   `json:"feels_like"`
+	Humidity      int64     `json:"humidity"`
+	WindSpeed     float64   `json:"wind_speed"`
+	WindDirection int64     `json:"wind_direction"`
+	WeatherCode   int64     `json:"weather_code"`
+	Precipitation float64   `json:"precipitation"`
+	CloudCover    int64     `json:"cloud_cover"`
+	IsDay         bool      `json:"is_day"`
+}
+
+func (q *Queries) InsertObservation(ctx context.Context, arg InsertObservationParams) error {
+	_, err := q.db.ExecContext(ctx, insertObservation,
+		arg.RecordedAt,
+		arg.Latitude,
+		arg.Longitude,
+		arg.Units,
+		arg.Temperature,
+		arg.FeelsLike,
+		arg.Humidity,
+		arg.WindSpeed,
+		arg.WindDirection,
+		arg.WeatherCode,
+		arg.Precipitation,
+		arg.CloudCover,
+		arg.IsDay,
+	)
+	return err
+}
+
+const listObservations = `-- name: ListObservations :many
+SELECT
+  id, recorded_at, latitude, longitude, units, temperature, feels_like, humidity, wind_speed, wind_direction, weather_code, precipitation, cloud_cover, is_day
+FROM
+  observations
+WHERE
+  recorded_at >= ?
+ORDER BY
+  recorded_at DESC
+LIMIT
+  ?
+`
+
+type ListObservationsParams struct {
+	RecordedAt time.Time `json:"recorded_at"`
+	Limit      int64     `json:"limit"`
+}
+
+func (q *Queries) ListObservations(ctx context.Context, arg ListObservationsParams) ([]Observation, error) {
+	rows, err := q.db.QueryContext(ctx, listObservations, arg.RecordedAt, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Observation{}
+	for rows.Next() {
+		var i Observation
+		if err := rows.Scan(
+			&i.ID,
+			&i.RecordedAt,
+			&i.Latitude,
+			&i.Longitude,
+			&i.Units,
+			&i.Temperature,
+			&i.FeelsLike,
+			&i.Humidity,
+			&i.WindSpeed,
+			&i.WindDirection,
+			&i.WeatherCode,
+			&i.Precipitation,
+			&i.CloudCover,
+			&i.IsDay,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,25 @@
+-- Weather observations recorded after each upstream fetch
+CREATE TABLE IF NOT EXISTS observations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    recorded_at TIMESTAMP NOT NULL,
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL,
+    units TEXT NOT NULL,
+    temperature REAL NOT NULL,
+    feels_like REAL NOT NULL,
+    humidity INTEGER NOT NULL,
+    wind_speed REAL NOT NULL,
+    wind_direction INTEGER NOT NULL,
+    weather_code INTEGER NOT NULL,
+    precipitation REAL NOT NULL,
+    cloud_cover INTEGER NOT NULL,
+    is_day BOOLEAN NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS observations_recorded_at ON observations (recorded_at);
+
+-- Record execution of this migration
+INSERT
+OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES
+    (002, '002-observations');
@@ -0,0 +1,13 @@
+-- Saved locations that can be selected with ?location=<name>
+CREATE TABLE IF NOT EXISTS locations (
+    name TEXT PRIMARY KEY,
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Record execution of this migration
+INSERT
+OR IGNORE INTO migrations (migration_number, migration_name)
+VALUES
+    (003, '003-locations');
@@ -0,0 +1,23 @@
+-- name: AddLocation :one
+INSERT INTO
+  locations (name, latitude, longitude, created_at)
+VALUES
+  (?, ?, ?, ?) ON CONFLICT (name) DO NOTHING
+RETURNING
+  *;
+
+-- name: ListLocations :many
+SELECT
+  *
+FROM
+  locations
+ORDER BY
+  name;
+
+-- name: LocationWithName :one
+SELECT
+  *
+FROM
+  locations
+WHERE
+  name = ?;
@@ -0,0 +1,31 @@
+-- name: InsertObservation :exec
+INSERT INTO
+  observations (
+    recorded_at,
+    latitude,
+    longitude,
+    units,
+    temperature,
+    feels_like,
+    humidity,
+    wind_speed,
+    wind_direction,
+    weather_code,
+    precipitation,
+    cloud_cover,
+    is_day
+  )
+VALUES
+  (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: ListObservations :many
+SELECT
+  *
+FROM
+  observations
+WHERE
+  recorded_at >= ?
+ORDER BY
+  recorded_at DESC
+LIMIT
+  ?;
@@ -0,0 +1,87 @@
+package srv
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const openMeteoAirQualityURL = "https://air-quality-api.open-meteo.com/v1/air-quality"
+
+// AirQuality is the current air quality from Open-Meteo's air-quality API
+type AirQuality struct {
+	PM25     float64
+	PM10     float64
+	USAQI    int
+	Category string
+}
+
+// Open-Meteo air-quality API response structure
+type openMeteoAirQualityResponse struct {
+	Current struct {
+		PM25  float64 `json:"pm2_5"`
+		PM10  float64 `json:"pm10"`
+		USAQI int     `json:"us_aqi"`
+	} `json:"current"`
+}
+
+// WithAirQualityURL sets the Open-Meteo compatible air-quality endpoint.
+func WithAirQualityURL(u string) Option {
+	return func(s *Server) {
+		s.AirQualityURL = u
+	}
+}
+
+// airQualityRequestURL builds the Open-Meteo air-quality URL for q. Air
+// quality has no unit variants, so q.Units is ignored.
+func (s *Server) airQualityRequestURL(q forecastQuery) string {
+	params := url.Values{}
+	params.Set("latitude", strconv.FormatFloat(q.Lat, 'f', 4, 64))
+	params.Set("longitude", strconv.FormatFloat(q.Lon, 'f', 4, 64))
+	params.Set("current", "pm2_5,pm10,us_aqi")
+	params.Set("timezone", s.Timezone)
+	return s.AirQualityURL + "?" + params.Encode()
+}
+
+func (s *Server) fetchAirQuality(ctx context.Context, q forecastQuery) (*AirQuality, error) {
+	resp, err := s.getWithRetry(ctx, s.airQualityRequestURL(q))
+	if err != nil {
+		return nil, fmt.Errorf("fetch air quality: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newUpstreamError(resp)
+	}
+
+	var data openMeteoAirQualityResponse
+	if err := decodeUpstream(resp.Body, &data); err != nil {
+		return nil, fmt.Errorf("decode air quality: %w", err)
+	}
+	return &AirQuality{
+		PM25:     data.Current.PM25,
+		PM10:     data.Current.PM10,
+		USAQI:    data.Current.USAQI,
+		Category: aqiCategory(data.Current.USAQI),
+	}, nil
+}
+
+// aqiCategory returns the EPA category for a US AQI value
+func aqiCategory(aqi int) string {
+	switch {
+	case aqi <= 50:
+		return "Good"
+	case aqi <= 100:
+		return "Moderate"
+	case aqi <= 150:
+		return "Unhealthy for Sensitive Groups"
+	case aqi <= 200:
+		return "Unhealthy"
+	case aqi <= 300:
+		return "Very Unhealthy"
+	default:
+		return "Hazardous"
+	}
+}
@@ -0,0 +1,176 @@
+package srv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchAirQuality(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	expected := AirQuality{PM25: 8.4, PM10: 14.2, USAQI: 42, Category: "Good"}
+	if forecast.AirQuality == nil || *forecast.AirQuality != expected {
+		t.Errorf("expected air quality %+v, got %+v", expected, forecast.AirQuality)
+	}
+
+	t.Run("page and API", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if body := w.Body.String(); !strings.Contains(body, "AQI 42 Good") {
+			t.Errorf("expected air quality on the page, got %q", body)
+		}
+
+		w = httptest.NewRecorder()
+		server.HandleAPI(w, httptest.NewRequest(http.MethodGet, "/api/weather", nil))
+		var response struct {
+			AirQuality *AirQuality `json:"air_quality"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if response.AirQuality == nil || response.AirQuality.USAQI != 42 {
+			t.Errorf("expected air_quality in API response, got %s", w.Body.String())
+		}
+	})
+}
+
+func TestFetchAirQualityFailure(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	t.Cleanup(failing.Close)
+	server, _ := newStubServer(t, stubForecastJSON, WithAirQualityURL(failing.URL))
+
+	forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+	if err != nil {
+		t.Fatalf("expected weather despite air quality failure, got %v", err)
+	}
+	if forecast.Current.Temperature != 41.3 {
+		t.Errorf("expected temperature 41.3, got %v", forecast.Current.Temperature)
+	}
+	if forecast.AirQuality != nil {
+		t.Errorf("expected no air quality, got %+v", forecast.AirQuality)
+	}
+}
+
+func TestFetchAirQualityConcurrent(t *testing.T) {
+	// The forecast stub only answers once the air-quality request has
+	// arrived, so the fetch can only succeed if both are in flight at once.
+	arrived := make(chan struct{})
+	airQuality := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		w.Write([]byte(stubAirQualityJSON))
+	}))
+	t.Cleanup(airQuality.Close)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-arrived:
+			w.Write([]byte(stubForecastJSON))
+		case <-time.After(5 * time.Second):
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	server, _ := newStubServer(t, stubForecastJSON, WithForecastURL(upstream.URL), WithAirQualityURL(airQuality.URL))
+
+	if _, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial)); err != nil {
+		t.Fatalf("expected concurrent fetches to succeed, got %v", err)
+	}
+}
+
+func TestFetchAirQualityCanceledOnForecastFailure(t *testing.T) {
+	// The air-quality stub hangs until its request is canceled, so the
+	// fetch only returns promptly if a failed forecast cancels it.
+	airQuality := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(airQuality.Close)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	t.Cleanup(failing.Close)
+	server, _ := newStubServer(t, stubForecastJSON, WithForecastURL(failing.URL), WithAirQualityURL(airQuality.URL))
+
+	start := time.Now()
+	if _, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial)); err == nil {
+		t.Fatal("expected the forecast failure to be returned")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the air-quality fetch to be canceled, waited %v", elapsed)
+	}
+}
+
+func TestAQICategory(t *testing.T) {
+	tests := []struct {
+		aqi      int
+		expected string
+	}{
+		{0, "Good"},
+		{50, "Good"},
+		{51, "Moderate"},
+		{101, "Unhealthy for Sensitive Groups"},
+		{200, "Unhealthy"},
+		{300, "Very Unhealthy"},
+		{301, "Hazardous"},
+	}
+	for _, test := range tests {
+		if got := aqiCategory(test.aqi); got != test.expected {
+			t.Errorf("aqiCategory(%d) = %q, expected %q", test.aqi, got, test.expected)
+		}
+	}
+}
+
+// BenchmarkRefreshForecast compares a refresh, which fetches the forecast
+// and air quality concurrently, with making the same two requests one
+// after the other, against stubs that each take 20ms to answer. On a
+// typical machine the concurrent refresh takes about 20ms and the
+// sequential one about 40ms.
+func BenchmarkRefreshForecast(b *testing.B) {
+	const latency = 20 * time.Millisecond
+	slow := func(body string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(latency)
+			w.Write([]byte(body))
+		}))
+		b.Cleanup(srv.Close)
+		return srv
+	}
+	upstream, airQuality := slow(stubForecastJSON), slow(stubAirQualityJSON)
+	server, err := New(filepath.Join(b.TempDir(), "bench.sqlite3"), "bench-hostname",
+		WithForecastURL(upstream.URL), WithAirQualityURL(airQuality.URL))
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+	q := server.defaultQuery(Imperial)
+
+	b.Run("concurrent", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := server.refreshForecast(ctx, q); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("sequential", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := server.fetchFromProviders(ctx, q); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := server.fetchAirQuality(ctx, q); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package srv
+
+// Alert severities, from least to most serious
+const (
+	alertAdvisory = "advisory"
+	alertWarning  = "warning"
+)
+
+// Wind gusts, in mph, at which the National Weather Service issues a wind
+// advisory and a high wind warning.
+const (
+	advisoryGustMPH = 45
+	warningGustMPH  = 58
+)
+
+// Alert is a banner-worthy warning about the current conditions.
+type Alert struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// severeAlert returns an alert for dangerous current conditions, or nil if
+// there is nothing to warn about. gust is in mph. When both the weather and
+// the wind call for an alert, the more severe one wins, preferring the
+// weather on a tie.
+func severeAlert(code int, gust float64) *Alert {
+	var alert *Alert
+	switch code {
+	case 95:
+		alert = &Alert{alertWarning, "Thunderstorm in the area. Stay indoors and away from windows."}
+	case 96, 99:
+		alert = &Alert{alertWarning, "Thunderstorm with hail. Stay indoors and keep vehicles under cover."}
+	case 66, 67:
+		alert = &Alert{alertWarning, "Freezing rain. Roads and sidewalks may be icy."}
+	case 75, 86:
+		alert = &Alert{alertWarning, "Heavy snow. Travel may be difficult."}
+	case 56, 57:
+		alert = &Alert{alertAdvisory, "Freezing drizzle. Watch for slippery surfaces."}
+	}
+	if alert != nil && alert.Severity == alertWarning {
+		return alert
+	}
+	switch {
+	case gust >= warningGustMPH:
+		return &Alert{alertWarning, "Damaging wind gusts. Secure loose objects outdoors."}
+	case gust >= advisoryGustMPH && alert == nil:
+		return &Alert{alertAdvisory, "Strong wind gusts. Use caution outdoors."}
+	}
+	return alert
+}
+
+// alertFor returns the alert for current conditions w in units, if any.
+func alertFor(w *WeatherData, units UnitSystem) *Alert {
+	if w == nil {
+		return nil
+	}
+	return severeAlert(w.WeatherCode, units.mph(w.WindGust))
+}
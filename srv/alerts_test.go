@@ -0,0 +1,93 @@
+package srv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSevereAlert(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     int
+		gust     float64
+		severity string
+		message  string
+	}{
+		{"calm", 3, 10, "", ""},
+		{"thunderstorm", 95, 0, alertWarning, "Thunderstorm in the area"},
+		{"thunderstorm with hail", 99, 0, alertWarning, "hail"},
+		{"freezing rain", 66, 0, alertWarning, "Freezing rain"},
+		{"heavy freezing rain", 67, 0, alertWarning, "Freezing rain"},
+		{"heavy snow", 75, 0, alertWarning, "Heavy snow"},
+		{"light snow", 71, 0, "", ""},
+		{"freezing drizzle", 56, 0, alertAdvisory, "Freezing drizzle"},
+		{"gust below advisory", 3, 44.9, "", ""},
+		{"gust advisory", 3, 45, alertAdvisory, "Strong wind gusts"},
+		{"gust warning", 3, 58, alertWarning, "Damaging wind gusts"},
+		{"drizzle advisory beats gust advisory", 56, 50, alertAdvisory, "Freezing drizzle"},
+		{"gust warning beats drizzle advisory", 56, 60, alertWarning, "Damaging wind gusts"},
+		{"storm beats gust warning", 95, 60, alertWarning, "Thunderstorm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alert := severeAlert(tt.code, tt.gust)
+			if tt.severity == "" {
+				if alert != nil {
+					t.Errorf("expected no alert, got %+v", alert)
+				}
+				return
+			}
+			if alert == nil {
+				t.Fatalf("expected a %s alert, got none", tt.severity)
+			}
+			if alert.Severity != tt.severity || !strings.Contains(alert.Message, tt.message) {
+				t.Errorf("got %+v, want %s containing %q", alert, tt.severity, tt.message)
+			}
+		})
+	}
+}
+
+func TestAlertForConvertsGusts(t *testing.T) {
+	// 80 km/h is just under 50 mph.
+	w := &WeatherData{WeatherCode: 3, WindGust: 80}
+	if alert := alertFor(w, Metric); alert == nil || alert.Severity != alertAdvisory {
+		t.Errorf("expected an advisory for 80 km/h gusts, got %+v", alert)
+	}
+	if alert := alertFor(&WeatherData{WeatherCode: 3, WindGust: 60}, Metric); alert != nil {
+		t.Errorf("expected no alert for 60 km/h gusts, got %+v", alert)
+	}
+}
+
+func TestAlertShown(t *testing.T) {
+	server, _ := newStubServer(t, `{"current": {"weather_code": 96, "is_day": 1}}`)
+
+	w := httptest.NewRecorder()
+	server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(w.Body.String(), `class="alert-banner alert-warning"`) {
+		t.Errorf("expected a warning banner, got body: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	server.HandleAPI(w, httptest.NewRequest(http.MethodGet, "/api/weather", nil))
+	var body struct {
+		Alert *Alert `json:"alert"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if body.Alert == nil || body.Alert.Severity != alertWarning {
+		t.Errorf("expected a warning alert in the API, got %+v", body.Alert)
+	}
+}
+
+func TestNoAlertInCalmWeather(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	w := httptest.NewRecorder()
+	server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if strings.Contains(w.Body.String(), "alert-banner") {
+		t.Errorf("expected no banner, got body: %s", w.Body.String())
+	}
+}
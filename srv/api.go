@@ -0,0 +1,359 @@
+package srv
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"srv.exe.dev/db/dbgen"
+)
+
+// Limits for the number of records returned by /api/history
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// HandleAPI returns the full forecast for the requested location and units.
+func (s *Server) HandleAPI(w http.ResponseWriter, r *http.Request) {
+	step, err := parseHourlyStep(r.URL.Query().Get("step"))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	q, forecast, cached, ok := s.forecastForRequest(w, r)
+	if !ok {
+		return
+	}
+	lang := requestLanguage(r)
+
+	response := struct {
+		Current        *WeatherData     `json:"current"`
+		Hourly         []HourlyForecast `json:"hourly"`
+		Daily          []DailyForecast  `json:"daily"`
+		PrecipTotal24h float64          `json:"precip_total_24h"`
+		TodayHigh      *float64         `json:"today_high"`
+		TodayLow       *float64         `json:"today_low"`
+		AirQuality     *AirQuality      `json:"air_quality"`
+		// Alert is null unless current conditions are dangerous.
+		Alert *Alert `json:"alert"`
+		// FetchedAt is when the forecast was fetched from upstream.
+		FetchedAt time.Time `json:"fetched_at"`
+		// Stale is true if the forecast is older than s.StaleOK, because
+		// recent upstream fetches have failed.
+		Stale bool `json:"stale"`
+		// Cached is true if the forecast was served from the cache rather
+		// than fetched for this request.
+		Cached bool `json:"cached"`
+		// Source credits the forecast's provider.
+		Source DataSource `json:"source"`
+	}{
+		Current:        localizeCurrent(forecast.Current, lang),
+		Hourly:         everyNthHour(s.upcomingHours(forecast.Hourly), step),
+		Daily:          localizeDaily(forecast.Daily, lang),
+		PrecipTotal24h: forecast.PrecipTotal24h,
+		TodayHigh:      forecast.TodayHigh,
+		TodayLow:       forecast.TodayLow,
+		AirQuality:     forecast.AirQuality,
+		Alert:          alertFor(forecast.Current, q.Units),
+		FetchedAt:      forecast.fetchedAt,
+		Stale:          s.Now().Sub(forecast.fetchedAt) > s.StaleOK,
+		Source:         openMeteoSource,
+	}
+	// The ETag is taken before setting Cached, so a forecast keeps its
+	// ETag when later responses serve it from the cache.
+	etag := ""
+	if body, err := json.Marshal(response); err == nil {
+		etag = etagFor(append(body, '\n'))
+	}
+	response.Cached = cached
+	writeJSONTagged(w, r, response, etag)
+}
+
+// HandleConditions returns the weather code table, keyed by WMO code, so
+// clients can show codes the same way the server does. Each entry adds the
+// condition's display text in the request's language.
+func (s *Server) HandleConditions(w http.ResponseWriter, r *http.Request) {
+	type condition struct {
+		weatherCondition
+		Text string `json:"text"`
+	}
+	lang := requestLanguage(r)
+	conditions := make(map[int]condition, len(weatherConditions))
+	for code, c := range weatherConditions {
+		conditions[code] = condition{c, translateCondition(lang, c.Condition)}
+	}
+	writeJSON(w, r, conditions)
+}
+
+// HandleAPICurrent returns only the current conditions, for small widgets
+// that don't need the forecast.
+func (s *Server) HandleAPICurrent(w http.ResponseWriter, r *http.Request) {
+	_, forecast, _, ok := s.forecastForRequest(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, r, currentResponse{localizeCurrent(forecast.Current, requestLanguage(r)), openMeteoSource})
+}
+
+// currentResponse is the current conditions with their attribution, as
+// /api/weather/current and the stream send them.
+type currentResponse struct {
+	*WeatherData
+	Source DataSource `json:"source"`
+}
+
+// Limits for the number of entries returned by /api/weather/hourly
+const (
+	defaultHourlyWindow = 24
+	maxHourlyWindow     = maxForecastHours
+)
+
+// HandleHourly returns just the hourly forecast, from the current hour, for
+// clients that render a long scrollable forecast. ?hours= sets how many
+// entries, from 1 to 168 with out of range values clamped; past the
+// server's ForecastHours a longer forecast is fetched for it.
+func (s *Server) HandleHourly(w http.ResponseWriter, r *http.Request) {
+	hours := defaultHourlyWindow
+	if v := r.URL.Query().Get("hours"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadRequest, "invalid_request", "hours must be a whole number")
+			return
+		}
+		hours = min(max(n, 1), maxHourlyWindow)
+	}
+	_, forecast, _, ok := s.forecastWithHours(w, r, hours)
+	if !ok {
+		return
+	}
+	hourly := s.upcomingHours(forecast.Hourly)
+	writeJSON(w, r, hourly[:min(len(hourly), hours)])
+}
+
+// HandleCSV returns the next 24 hours of the hourly forecast as CSV, for
+// spreadsheets.
+func (s *Server) HandleCSV(w http.ResponseWriter, r *http.Request) {
+	step, err := parseHourlyStep(r.URL.Query().Get("step"))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	_, forecast, _, ok := s.forecastForRequest(w, r)
+	if !ok {
+		return
+	}
+	lang := requestLanguage(r)
+	hourly := s.upcomingHours(forecast.Hourly)
+	hourly = everyNthHour(hourly[:min(len(hourly), 24)], step)
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="weather.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "temperature", "weather_code", "condition", "precip_probability"})
+	for _, h := range hourly {
+		key, _ := weatherCodeToCondition(h.WeatherCode, h.IsDay)
+		cw.Write([]string{
+			h.Time,
+			strconv.FormatFloat(h.Temperature, 'f', -1, 64),
+			strconv.Itoa(h.WeatherCode),
+			translateCondition(lang, key),
+			strconv.Itoa(h.PrecipProb),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		slog.WarnContext(r.Context(), "write csv", "error", err)
+	}
+}
+
+// HandleRaw returns the Open-Meteo response behind the current forecast,
+// decoded but otherwise untransformed, for debugging differences between
+// our fields and the source. It is served from the same cache as the other
+// weather endpoints.
+func (s *Server) HandleRaw(w http.ResponseWriter, r *http.Request) {
+	_, forecast, _, ok := s.forecastForRequest(w, r)
+	if !ok {
+		return
+	}
+	if forecast.raw == nil {
+		writeJSONError(w, r, http.StatusServiceUnavailable, "upstream_unavailable", "Forecast came from a fallback provider; no Open-Meteo data available")
+		return
+	}
+	writeJSON(w, r, struct {
+		*openMeteoResponse
+		Source DataSource `json:"source"`
+	}{forecast.raw, openMeteoSource})
+}
+
+// forecastForRequest resolves the ?units=, ?location= and ?q= parameters
+// and fetches the matching forecast through the shared cache, reporting
+// whether it was cached. ?refresh=1 bypasses the cache, within limits. On
+// failure it writes the error response and returns ok false.
+func (s *Server) forecastForRequest(w http.ResponseWriter, r *http.Request) (q forecastQuery, forecast *Forecast, cached, ok bool) {
+	return s.forecastWithHours(w, r, 0)
+}
+
+// forecastWithHours is forecastForRequest for a forecast with at least
+// hours hourly entries.
+func (s *Server) forecastWithHours(w http.ResponseWriter, r *http.Request, hours int) (q forecastQuery, forecast *Forecast, cached, ok bool) {
+	units, err := parseUnitSystem(r.URL.Query().Get("units"))
+	if err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, "invalid_request", err.Error())
+		return forecastQuery{}, nil, false, false
+	}
+	_, q, err = s.locationForRequest(r, units)
+	switch {
+	case errors.Is(err, errEmptyPlaceQuery):
+		writeJSONError(w, r, http.StatusBadRequest, "invalid_request", err.Error())
+		return forecastQuery{}, nil, false, false
+	case errors.Is(err, errUnknownPlace):
+		writeJSONError(w, r, http.StatusNotFound, "unknown_location", "No place matches q")
+		return forecastQuery{}, nil, false, false
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONError(w, r, http.StatusNotFound, "unknown_location", "Unknown location")
+		return forecastQuery{}, nil, false, false
+	case errors.Is(err, errGeocodingUnavailable):
+		slog.ErrorContext(r.Context(), "geocode", "error", err)
+		writeJSONError(w, r, http.StatusServiceUnavailable, "upstream_unavailable", "Unable to look up place")
+		return forecastQuery{}, nil, false, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "resolve location", "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, "internal_error", "Unable to look up location")
+		return forecastQuery{}, nil, false, false
+	}
+
+	q.Hours = s.upstreamHours(hours)
+	forecast, cached, err = s.lookupForecast(r.Context(), q, s.forceRefresh(r, q))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "fetch weather", "error", err)
+		// Fall back to the last good forecast while it is young enough to
+		// serve.
+		if last, fetchedAt, ok := s.cache.get(q, s.Now()); ok && s.Now().Sub(fetchedAt) <= s.StaleMax {
+			setSourceLink(w)
+			return q, last, true, true
+		}
+		var upstreamErr *UpstreamError
+		if errors.As(err, &upstreamErr) && upstreamErr.StatusCode == http.StatusTooManyRequests {
+			writeJSONError(w, r, http.StatusTooManyRequests, "upstream_rate_limited", "Weather provider rate limit reached")
+			return forecastQuery{}, nil, false, false
+		}
+		writeJSONError(w, r, http.StatusServiceUnavailable, "upstream_unavailable", "Unable to fetch weather")
+		return forecastQuery{}, nil, false, false
+	}
+	// The cache can outlive StaleMax while the background refresher keeps
+	// failing.
+	if s.Now().Sub(forecast.fetchedAt) > s.StaleMax {
+		writeJSONError(w, r, http.StatusServiceUnavailable, "upstream_unavailable", "Latest forecast is too old to serve")
+		return forecastQuery{}, nil, false, false
+	}
+	setSourceLink(w)
+	return q, forecast, cached, true
+}
+
+// writeJSON encodes v with an ETag, answering 304 Not Modified when the
+// request's If-None-Match already has it. Only GET and HEAD are
+// conditional; other methods, whose responses report what they did,
+// always get the body. v is encoded before anything is
+// written, so a value that can't be encoded, like a NaN temperature, gets
+// a 500 error rather than a truncated body.
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	writeJSONTagged(w, r, v, "")
+}
+
+// writeJSONTagged is writeJSON with the ETag supplied by the caller,
+// derived from the body if etag is empty.
+func writeJSONTagged(w http.ResponseWriter, r *http.Request, v any, etag string) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "encode response", "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, "internal_error", "Unable to encode response")
+		return
+	}
+	body = append(body, '\n')
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		if etag == "" {
+			etag = etagFor(body)
+		}
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// writeJSONError writes an API error as a JSON body like
+// {"error": "Unknown location", "code": "unknown_location", "request_id": "..."}.
+// code is a stable identifier clients can match on; message is for humans
+// and the request ID lets them quote the failure in a bug report.
+func writeJSONError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error     string `json:"error"`
+		Code      string `json:"code"`
+		RequestID string `json:"request_id,omitempty"`
+	}{Error: message, Code: code, RequestID: requestIDFrom(r.Context())})
+}
+
+// etagFor returns a strong ETag derived from the response body.
+func etagFor(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleHistory returns recorded observations, newest first. It accepts an
+// RFC3339 ?since= lower bound and a ?limit= on the number of records.
+func (s *Server) HandleHistory(w http.ResponseWriter, r *http.Request) {
+	params := dbgen.ListObservationsParams{Limit: defaultHistoryLimit}
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeJSONError(w, r, http.StatusBadRequest, "invalid_request", "since must be an RFC3339 timestamp")
+			return
+		}
+		params.RecordedAt = since.UTC()
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit < 1 {
+			writeJSONError(w, r, http.StatusBadRequest, "invalid_request", "limit must be a positive integer")
+			return
+		}
+		params.Limit = min(limit, maxHistoryLimit)
+	}
+
+	observations, err := s.Store.ListObservations(r.Context(), params)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "list observations", "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, "internal_error", "Unable to load history")
+		return
+	}
+
+	writeJSON(w, r, observations)
+}
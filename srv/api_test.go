@@ -0,0 +1,669 @@
+package srv
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"srv.exe.dev/db/dbgen"
+)
+
+func TestHandleAPIConditionalGet(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/weather", nil)
+	w := httptest.NewRecorder()
+	server.HandleAPI(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected ETag header")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/weather", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	server.HandleAPI(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected status 304, got %d", w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("expected empty body on 304, got %q", w.Body.String())
+	}
+	if w.Header().Get("ETag") != etag {
+		t.Errorf("expected ETag %s on 304, got %s", etag, w.Header().Get("ETag"))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/weather", nil)
+	req.Header.Set("If-None-Match", `"stale"`)
+	w = httptest.NewRecorder()
+	server.HandleAPI(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200 for mismatched ETag, got %d", w.Code)
+	}
+}
+
+func TestHandleAPIFreshness(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	get := func() (etag string, body struct {
+		FetchedAt time.Time `json:"fetched_at"`
+		Cached    bool      `json:"cached"`
+	}) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.HandleAPI(w, httptest.NewRequest(http.MethodGet, "/api/weather", nil))
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return w.Header().Get("ETag"), body
+	}
+
+	firstETag, first := get()
+	if first.Cached {
+		t.Error("expected the first response to be fetched, not cached")
+	}
+	if !first.FetchedAt.Equal(stubNow) {
+		t.Errorf("expected fetched_at %v, got %v", stubNow, first.FetchedAt)
+	}
+
+	advanceClock(server, time.Minute)
+	secondETag, second := get()
+	if !second.Cached {
+		t.Error("expected the second response to come from the cache")
+	}
+	if !second.FetchedAt.Equal(stubNow) {
+		t.Errorf("expected the cached fetched_at %v, got %v", stubNow, second.FetchedAt)
+	}
+	if secondETag != firstETag {
+		t.Errorf("expected the ETag to ignore cached, got %s then %s", firstETag, secondETag)
+	}
+
+	advanceClock(server, server.CacheTTL)
+	if _, third := get(); third.Cached || !third.FetchedAt.Equal(server.Now()) {
+		t.Errorf("expected a refetch after the TTL, got %+v", third)
+	}
+}
+
+func TestHandleAPIStaleLimits(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	server, _ := newStubServer(t, stubForecastJSON, WithStaleLimits(10*time.Minute, time.Hour))
+	if _, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial)); err != nil {
+		t.Fatalf("warm cache: %v", err)
+	}
+	server.ForecastURL = failing.URL
+
+	tests := []struct {
+		age    time.Duration
+		status int
+		stale  bool
+	}{
+		{10 * time.Minute, http.StatusOK, false},
+		{10*time.Minute + time.Second, http.StatusOK, true},
+		{time.Hour, http.StatusOK, true},
+		{time.Hour + time.Second, http.StatusServiceUnavailable, false},
+	}
+	for _, refreshing := range []bool{false, true} {
+		server.refreshing.Store(refreshing)
+		for _, test := range tests {
+			server.Now = func() time.Time { return stubNow.Add(test.age) }
+			w := httptest.NewRecorder()
+			server.HandleAPI(w, httptest.NewRequest(http.MethodGet, "/api/weather", nil))
+			if w.Code != test.status {
+				t.Errorf("refreshing %v, age %v: expected status %d, got %d", refreshing, test.age, test.status, w.Code)
+				continue
+			}
+			var body struct {
+				Stale  bool   `json:"stale"`
+				Cached bool   `json:"cached"`
+				Code   string `json:"code"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if test.status != http.StatusOK {
+				if body.Code != "upstream_unavailable" {
+					t.Errorf("refreshing %v, age %v: expected upstream_unavailable, got %q", refreshing, test.age, body.Code)
+				}
+				continue
+			}
+			if body.Stale != test.stale || !body.Cached {
+				t.Errorf("refreshing %v, age %v: got stale %v, cached %v; want stale %v, cached", refreshing, test.age, body.Stale, body.Cached, test.stale)
+			}
+		}
+	}
+
+	for _, limits := range [][2]time.Duration{{0, time.Hour}, {time.Hour, time.Minute}} {
+		if _, err := New(filepath.Join(t.TempDir(), "db.sqlite3"), "test-hostname", WithStaleLimits(limits[0], limits[1])); err == nil {
+			t.Errorf("expected an error for stale limits %v", limits)
+		}
+	}
+}
+
+func TestHandleAPIHourlyStep(t *testing.T) {
+	server, hits := newStubServer(t, stubForecastJSON)
+	hourTimes := func(path string) []string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.HandleAPI(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var body struct {
+			Hourly []HourlyForecast `json:"hourly"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode %s: %v", path, err)
+		}
+		var times []string
+		for _, h := range body.Hourly {
+			times = append(times, h.Time)
+		}
+		return times
+	}
+
+	if got, want := hourTimes("/api/weather?step=2"), []string{"2025-01-15T14:00", "2025-01-15T16:00"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("step=2 hours = %v, want %v", got, want)
+	}
+	// Thinning must not reach the cached forecast.
+	if got := hourTimes("/api/weather"); len(got) != 3 {
+		t.Errorf("expected all 3 hours without a step, got %v", got)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("expected one upstream fetch, got %d", n)
+	}
+}
+
+func TestHandleHourly(t *testing.T) {
+	var requestedHours []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedHours = append(requestedHours, r.URL.Query().Get("forecast_hours"))
+		w.Write([]byte(stubForecastJSON))
+	}))
+	defer upstream.Close()
+	server, _ := newStubServer(t, stubForecastJSON, WithForecastURL(upstream.URL))
+	handler := server.routes()
+
+	tests := []struct {
+		query    string
+		entries  int
+		upstream []string // forecast_hours of every upstream request so far
+	}{
+		{"", 3, []string{"24"}},
+		{"?hours=2", 2, []string{"24"}},
+		{"?hours=0", 1, []string{"24"}},
+		{"?hours=30", 3, []string{"24", "48"}},
+		{"?hours=500", 3, []string{"24", "48", "168"}},
+		{"?hours=168", 3, []string{"24", "48", "168"}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/weather/hourly"+test.query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", test.query, w.Code)
+		}
+		var hourly []HourlyForecast
+		if err := json.Unmarshal(w.Body.Bytes(), &hourly); err != nil {
+			t.Fatalf("%s: decode: %v", test.query, err)
+		}
+		if len(hourly) != test.entries || hourly[0].Time != "2025-01-15T14:00" {
+			t.Errorf("%s: expected %d entries from 14:00, got %+v", test.query, test.entries, hourly)
+		}
+		if !reflect.DeepEqual(requestedHours, test.upstream) {
+			t.Errorf("%s: upstream forecast_hours %v, want %v", test.query, requestedHours, test.upstream)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/weather/hourly?hours=two", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for a non-numeric hours, got %d", w.Code)
+	}
+}
+
+func TestWriteJSONEncodeFailure(t *testing.T) {
+	check := func(t *testing.T, w *httptest.ResponseRecorder) {
+		t.Helper()
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("expected status 500, got %d", w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("expected a JSON error, got Content-Type %q", ct)
+		}
+		if etag := w.Header().Get("ETag"); etag != "" {
+			t.Errorf("expected no ETag on an error, got %q", etag)
+		}
+		var body struct {
+			Code string `json:"code"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != "internal_error" {
+			t.Errorf("expected a complete internal_error body, got %q (%v)", w.Body.String(), err)
+		}
+	}
+
+	t.Run("unencodable value", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		writeJSON(w, httptest.NewRequest(http.MethodGet, "/", nil), struct{ C chan int }{make(chan int)})
+		check(t, w)
+	})
+
+	t.Run("NaN from a provider", func(t *testing.T) {
+		provider := &fakeProvider{forecast: &Forecast{Current: &WeatherData{Temperature: math.NaN()}}}
+		server, _ := newStubServer(t, stubForecastJSON, WithProviders(provider))
+		w := httptest.NewRecorder()
+		server.HandleAPI(w, httptest.NewRequest(http.MethodGet, "/api/weather", nil))
+		check(t, w)
+	})
+}
+
+func TestHandleConditions(t *testing.T) {
+	type condition struct {
+		Condition  string `json:"condition"`
+		Text       string `json:"text"`
+		DayEmoji   string `json:"day_emoji"`
+		NightEmoji string `json:"night_emoji"`
+	}
+	server, _ := newStubServer(t, stubForecastJSON)
+	get := func(t *testing.T, target string) map[string]condition {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+		var conditions map[string]condition
+		if err := json.Unmarshal(w.Body.Bytes(), &conditions); err != nil {
+			t.Fatalf("decode conditions: %v", err)
+		}
+		return conditions
+	}
+
+	conditions := get(t, "/api/conditions")
+	if len(conditions) != len(weatherConditions) {
+		t.Errorf("expected %d codes, got %d", len(weatherConditions), len(conditions))
+	}
+	for code := range weatherConditions {
+		c, ok := conditions[strconv.Itoa(code)]
+		if !ok {
+			t.Errorf("code %d is missing", code)
+			continue
+		}
+		dayKey, dayEmoji := weatherCodeToCondition(code, true)
+		_, nightEmoji := weatherCodeToCondition(code, false)
+		if c.Condition != dayKey || c.DayEmoji != dayEmoji || c.NightEmoji != nightEmoji {
+			t.Errorf("code %d: got %+v, want %s %s %s", code, c, dayKey, dayEmoji, nightEmoji)
+		}
+		if c.Text != translateCondition(defaultLanguage, dayKey) {
+			t.Errorf("code %d: expected English text, got %q", code, c.Text)
+		}
+	}
+
+	if c := get(t, "/api/conditions?lang=es")["3"]; c.Text != "Nublado" {
+		t.Errorf("expected Spanish text for code 3, got %q", c.Text)
+	}
+}
+
+func TestHandleAPICurrent(t *testing.T) {
+	server, hits := newStubServer(t, stubForecastJSON)
+	handler := server.routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/weather", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("/api/weather: expected status 200, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/weather/current", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected Content-Type application/json, got %q", ct)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	for _, key := range []string{"hourly", "daily", "current"} {
+		if _, ok := fields[key]; ok {
+			t.Errorf("expected only current conditions, found %q in %s", key, w.Body.String())
+		}
+	}
+	var current WeatherData
+	if err := json.Unmarshal(w.Body.Bytes(), &current); err != nil {
+		t.Fatalf("decode current: %v", err)
+	}
+	if current.Temperature != 41.3 {
+		t.Errorf("expected temperature 41.3, got %v", current.Temperature)
+	}
+	if hits.Load() != 1 {
+		t.Errorf("expected both endpoints to share one upstream fetch, got %d", hits.Load())
+	}
+}
+
+func TestHandleRaw(t *testing.T) {
+	t.Run("passes the upstream fields through", func(t *testing.T) {
+		server, hits := newStubServer(t, stubForecastJSON)
+		handler := server.routes()
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/raw", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var raw struct {
+			Current map[string]any `json:"current"`
+			Hourly  map[string]any `json:"hourly"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		// Untransformed: visibility stays in meters and is_day stays 0/1.
+		if raw.Current["visibility"] != 16093.44 || raw.Current["is_day"] != 1.0 {
+			t.Errorf("expected upstream values, got %v", raw.Current)
+		}
+		if _, ok := raw.Hourly["temperature_2m"]; !ok {
+			t.Errorf("expected hourly temperature_2m, got %v", raw.Hourly)
+		}
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/weather", nil))
+		if hits.Load() != 1 {
+			t.Errorf("expected /api/raw to share the forecast cache, got %d upstream fetches", hits.Load())
+		}
+	})
+
+	t.Run("requires the API token", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON, WithAPIToken("s3cret"))
+		w := httptest.NewRecorder()
+		server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/raw", nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %d", w.Code)
+		}
+	})
+
+	t.Run("fallback provider", func(t *testing.T) {
+		provider := &fakeProvider{forecast: &Forecast{Current: &WeatherData{Temperature: 50}}}
+		server, _ := newStubServer(t, stubForecastJSON, WithProviders(provider))
+		w := httptest.NewRecorder()
+		server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/raw", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected status 503, got %d", w.Code)
+		}
+	})
+}
+
+func TestHandleHistory(t *testing.T) {
+	store := newMemStore()
+	server, _ := newStubServer(t, stubForecastJSON, WithStore(store))
+	base := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		err := store.InsertObservation(context.Background(), dbgen.InsertObservationParams{
+			RecordedAt:  base.Add(time.Duration(i) * time.Hour),
+			Units:       string(Imperial),
+			Temperature: float64(40 + i),
+		})
+		if err != nil {
+			t.Fatalf("insert observation: %v", err)
+		}
+	}
+
+	get := func(t *testing.T, query string) (*httptest.ResponseRecorder, []dbgen.Observation) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/history"+query, nil)
+		w := httptest.NewRecorder()
+		server.HandleHistory(w, req)
+		var observations []dbgen.Observation
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &observations); err != nil {
+				t.Fatalf("decode history: %v", err)
+			}
+		}
+		return w, observations
+	}
+
+	t.Run("newest first", func(t *testing.T) {
+		_, observations := get(t, "")
+		if len(observations) != 5 {
+			t.Fatalf("expected 5 observations, got %d", len(observations))
+		}
+		if observations[0].Temperature != 44 || observations[4].Temperature != 40 {
+			t.Errorf("expected descending order, got %+v", observations)
+		}
+	})
+
+	t.Run("since and limit", func(t *testing.T) {
+		_, observations := get(t, "?since=2025-01-15T09:00:00-05:00&limit=2")
+		if len(observations) != 2 {
+			t.Fatalf("expected 2 observations, got %d", len(observations))
+		}
+		if observations[0].Temperature != 44 || observations[1].Temperature != 43 {
+			t.Errorf("unexpected observations: %+v", observations)
+		}
+		_, observations = get(t, "?since=2025-01-15T15:00:00Z")
+		if len(observations) != 2 {
+			t.Errorf("expected 2 observations since 15:00Z, got %d", len(observations))
+		}
+	})
+
+	t.Run("invalid params", func(t *testing.T) {
+		for _, query := range []string{"?since=yesterday", "?limit=0", "?limit=abc"} {
+			w, _ := get(t, query)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status 400, got %d", query, w.Code)
+			}
+		}
+	})
+
+	t.Run("store failure", func(t *testing.T) {
+		store.fail(errors.New("disk full"))
+		w, _ := get(t, "")
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("expected status 500, got %d", w.Code)
+		}
+	})
+}
+
+func TestAPIErrorEnvelope(t *testing.T) {
+	upstreamStatus := func(status int) string {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		t.Cleanup(upstream.Close)
+		return upstream.URL
+	}
+
+	tests := []struct {
+		name        string
+		forecastURL string
+		path        string
+		status      int
+		code        string
+	}{
+		{"bad units", "", "/api/weather?units=kelvin", http.StatusBadRequest, "invalid_request"},
+		{"unknown location", "", "/api/weather/current?location=Atlantis", http.StatusNotFound, "unknown_location"},
+		{"bad step", "", "/api/weather?step=25", http.StatusBadRequest, "invalid_request"},
+		{"bad history limit", "", "/api/history?limit=0", http.StatusBadRequest, "invalid_request"},
+		{"upstream down", upstreamStatus(http.StatusBadRequest), "/api/weather", http.StatusServiceUnavailable, "upstream_unavailable"},
+		{"upstream rate limited", upstreamStatus(http.StatusTooManyRequests), "/api/weather", http.StatusTooManyRequests, "upstream_rate_limited"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := newStubServer(t, stubForecastJSON)
+			if test.forecastURL != "" {
+				server.ForecastURL = test.forecastURL
+			}
+			w := httptest.NewRecorder()
+			server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if w.Code != test.status {
+				t.Errorf("expected status %d, got %d", test.status, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("expected Content-Type application/json, got %q", ct)
+			}
+			var body struct {
+				Error string `json:"error"`
+				Code  string `json:"code"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode error body %q: %v", w.Body.String(), err)
+			}
+			if body.Code != test.code || body.Error == "" {
+				t.Errorf("expected code %q with a message, got %+v", test.code, body)
+			}
+		})
+	}
+}
+
+func TestHandleCSV(t *testing.T) {
+	server, hits := newStubServer(t, stubForecastJSON)
+	handler := server.routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/weather.csv", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("expected Content-Type text/csv, got %q", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, `filename="weather.csv"`) {
+		t.Errorf("expected a weather.csv filename, got %q", cd)
+	}
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parse csv: %v", err)
+	}
+	expected := [][]string{
+		{"time", "temperature", "weather_code", "condition", "precip_probability"},
+		{"2025-01-15T14:00", "41.3", "3", "Overcast", "5"},
+		{"2025-01-15T15:00", "40.8", "3", "Overcast", "10"},
+		{"2025-01-15T16:00", "39.9", "61", "Rain", "40"},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("unexpected csv:\n%v\nexpected:\n%v", records, expected)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/weather", nil))
+	if n := hits.Load(); n != 1 {
+		t.Errorf("expected the CSV and JSON endpoints to share one upstream fetch, got %d", n)
+	}
+}
+
+func TestVersionedAPIRoutes(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	handler := server.routes()
+	get := func(t *testing.T, method, target string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	for _, path := range []string{"/weather", "/weather/current", "/weather/hourly?hours=2"} {
+		// Warm the cache so both responses report cached.
+		get(t, http.MethodGet, "/api"+path)
+		unversioned, v1 := get(t, http.MethodGet, "/api"+path), get(t, http.MethodGet, "/api/v1"+path)
+		if unversioned.Code != http.StatusOK || v1.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200 from both, got %d and %d", path, unversioned.Code, v1.Code)
+		}
+		if unversioned.Body.String() != v1.Body.String() {
+			t.Errorf("%s: expected equivalent bodies, got\n%s\nand\n%s", path, unversioned.Body, v1.Body)
+		}
+		if w := get(t, http.MethodOptions, "/api/v1"+path); w.Code != http.StatusNoContent {
+			t.Errorf("%s: expected a preflight response for v1, got %d", path, w.Code)
+		}
+	}
+
+	if w := get(t, http.MethodGet, "/api/v2/weather"); w.Code != http.StatusNotFound {
+		t.Errorf("expected /api/v2/weather to be unused for now, got %d", w.Code)
+	}
+}
+
+func TestDataSourceAttribution(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	ts := httptest.NewServer(server.routes())
+	t.Cleanup(ts.Close)
+	wantLink := `<https://open-meteo.com/>; rel="via"; title="Open-Meteo (CC BY 4.0)"`
+
+	checkSource := func(t *testing.T, data []byte) {
+		t.Helper()
+		var body struct {
+			Source DataSource `json:"source"`
+		}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if body.Source != openMeteoSource {
+			t.Errorf("expected source %+v, got %+v", openMeteoSource, body.Source)
+		}
+	}
+
+	// Objects carry a source field; every forecast response has the Link.
+	for _, test := range []struct {
+		path   string
+		object bool
+	}{
+		{"/api/weather", true},
+		{"/api/v1/weather/current", true},
+		{"/api/raw", true},
+		{"/api/weather/hourly", false},
+		{"/api/weather.csv", false},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", w.Code)
+			}
+			if got := w.Header().Get("Link"); got != wantLink {
+				t.Errorf("expected Link %s, got %q", wantLink, got)
+			}
+			if test.object {
+				checkSource(t, w.Body.Bytes())
+			}
+		})
+	}
+
+	t.Run("stream", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/api/weather/stream")
+		if err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		defer resp.Body.Close()
+		if got := resp.Header.Get("Link"); got != wantLink {
+			t.Errorf("expected Link %s, got %q", wantLink, got)
+		}
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				checkSource(t, []byte(data))
+				return
+			}
+		}
+		t.Fatal("stream closed before its first event")
+	})
+
+	link := `<a href="https://open-meteo.com/" target="_blank">Open-Meteo</a>`
+	if page := renderRoot(t, server, "/").Body.String(); !strings.Contains(page, link) {
+		t.Errorf("expected the page to credit Open-Meteo, got %s", page)
+	}
+	if page := renderRoot(t, server, "/?compact=1").Body.String(); !strings.Contains(page, `href="https://open-meteo.com/"`) {
+		t.Errorf("expected the compact page to credit Open-Meteo, got %s", page)
+	}
+}
@@ -0,0 +1,63 @@
+package srv
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// embeddedAssets holds the templates and static files, so the binary runs
+// without the source tree next to it.
+//
+//go:embed templates static
+var embeddedAssets embed.FS
+
+// WithTemplatesDir loads templates from dir instead of the copies embedded
+// in the binary.
+func WithTemplatesDir(dir string) Option {
+	return func(s *Server) {
+		s.TemplatesDir = dir
+	}
+}
+
+// WithStaticDir serves static assets from dir instead of the copies
+// embedded in the binary.
+func WithStaticDir(dir string) Option {
+	return func(s *Server) {
+		s.StaticDir = dir
+	}
+}
+
+// sourceDir returns the named directory beside this file in the source
+// tree, or "" if the source isn't present, as when the binary has been
+// copied elsewhere.
+func sourceDir(name string) string {
+	_, thisFile, _, ok := runtime.Caller(0)
+	if !ok {
+		return ""
+	}
+	dir := filepath.Join(filepath.Dir(thisFile), name)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return ""
+	}
+	return dir
+}
+
+// assetFS returns dir as a file system, or the embedded copy of name if
+// dir is empty.
+func assetFS(dir, name string) (fs.FS, error) {
+	if dir == "" {
+		return fs.Sub(embeddedAssets, name)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s directory: %w", name, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s directory %s is not a directory", name, dir)
+	}
+	return os.DirFS(dir), nil
+}
@@ -0,0 +1,74 @@
+package srv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAssetDirs(t *testing.T) {
+	t.Run("embedded by default", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON)
+		if server.TemplatesDir != "" || server.StaticDir != "" {
+			t.Errorf("expected embedded assets, got templates %q, static %q", server.TemplatesDir, server.StaticDir)
+		}
+		w := httptest.NewRecorder()
+		server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d", w.Code)
+		}
+	})
+
+	t.Run("custom directories", func(t *testing.T) {
+		templates, static := t.TempDir(), t.TempDir()
+		page := `<p>custom {{.Location}}</p>`
+		if err := os.WriteFile(filepath.Join(templates, "weather.html"), []byte(page), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(static, "extra.css"), []byte("body{}"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		server, _ := newStubServer(t, stubForecastJSON, WithTemplatesDir(templates), WithStaticDir(static))
+		handler := server.routes()
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if !strings.Contains(w.Body.String(), "custom Brooklyn") {
+			t.Errorf("expected the custom template, got %q", w.Body.String())
+		}
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/static/extra.css", nil))
+		if w.Code != http.StatusOK || w.Body.String() != "body{}" {
+			t.Errorf("expected the custom static file, got %d %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("dev mode reads the source tree", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON, WithDevMode(true))
+		if filepath.Base(server.TemplatesDir) != "templates" || filepath.Base(server.StaticDir) != "static" {
+			t.Errorf("expected source directories, got templates %q, static %q", server.TemplatesDir, server.StaticDir)
+		}
+	})
+
+	t.Run("missing templates directory", func(t *testing.T) {
+		missing := filepath.Join(t.TempDir(), "nope")
+		_, err := New(filepath.Join(t.TempDir(), "db.sqlite3"), "test-hostname", WithTemplatesDir(missing))
+		if err == nil || !strings.Contains(err.Error(), "templates directory") || !strings.Contains(err.Error(), missing) {
+			t.Errorf("expected an error naming the missing templates directory, got %v", err)
+		}
+	})
+
+	t.Run("templates directory without the page", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "other.html"), []byte("<p>other</p>"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := New(filepath.Join(t.TempDir(), "db.sqlite3"), "test-hostname", WithTemplatesDir(dir))
+		if err == nil || !strings.Contains(err.Error(), pageTemplate) || !strings.Contains(err.Error(), dir) {
+			t.Errorf("expected an error naming the directory and %s, got %v", pageTemplate, err)
+		}
+	})
+}
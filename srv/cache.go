@@ -0,0 +1,192 @@
+package srv
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCachedForecasts bounds the forecast cache, since any coordinates can
+// be asked for with ?q=. The background refresher also refetches every
+// cached forecast, so this bounds its work too.
+const maxCachedForecasts = 256
+
+// weatherCache holds the most recent successful fetch per query so
+// handlers don't query Open-Meteo on every request. Entries keep their
+// fetch time, which callers check against CacheTTL and the stale limits,
+// and when they were last looked up, so the refresher can drop the ones
+// nobody asks for. Past max entries the least recently used is evicted,
+// which only bounds memory. It is safe for concurrent use.
+type weatherCache struct {
+	mu      sync.Mutex
+	max     int // maxCachedForecasts if 0
+	entries map[forecastQuery]*list.Element
+	recency list.List // of *cacheEntry, most recently used first
+}
+
+type cacheEntry struct {
+	query     forecastQuery
+	forecast  *Forecast
+	fetchedAt time.Time
+	usedAt    time.Time // last lookup, or fetchedAt if none yet
+}
+
+// get returns the cached forecast for q and when it was fetched, and
+// records the lookup at now.
+func (c *weatherCache) get(q forecastQuery, now time.Time) (*Forecast, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[q]
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	c.recency.MoveToFront(el)
+	e := el.Value.(*cacheEntry)
+	e.usedAt = now
+	return e.forecast, e.fetchedAt, true
+}
+
+// evictIdle removes the forecasts not looked up since cutoff and returns
+// how many there were.
+func (c *weatherCache) evictIdle(cutoff time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for el := c.recency.Front(); el != nil; {
+		next := el.Next()
+		if e := el.Value.(*cacheEntry); e.usedAt.Before(cutoff) {
+			c.recency.Remove(el)
+			delete(c.entries, e.query)
+			n++
+		}
+		el = next
+	}
+	return n
+}
+
+// keys returns the queries that currently have a cached forecast, most
+// recently used first.
+func (c *weatherCache) keys() []forecastQuery {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]forecastQuery, 0, len(c.entries))
+	for el := c.recency.Front(); el != nil; el = el.Next() {
+		keys = append(keys, el.Value.(*cacheEntry).query)
+	}
+	return keys
+}
+
+func (c *weatherCache) set(q forecastQuery, forecast *Forecast, fetchedAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[forecastQuery]*list.Element)
+	}
+	if el, ok := c.entries[q]; ok {
+		// Only lookups count as use, so background refreshes don't keep
+		// forecasts nobody asks for.
+		e := el.Value.(*cacheEntry)
+		el.Value = &cacheEntry{query: q, forecast: forecast, fetchedAt: fetchedAt, usedAt: e.usedAt}
+		return
+	}
+	c.entries[q] = c.recency.PushFront(&cacheEntry{query: q, forecast: forecast, fetchedAt: fetchedAt, usedAt: fetchedAt})
+	limit := c.max
+	if limit == 0 {
+		limit = maxCachedForecasts
+	}
+	for len(c.entries) > limit {
+		oldest := c.recency.Back()
+		c.recency.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).query)
+	}
+}
+
+// flush removes every cached forecast and returns how many there were.
+func (c *weatherCache) flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.entries)
+	clear(c.entries)
+	c.recency.Init()
+	return n
+}
+
+// HandleFlushCache empties the forecast cache, so the next requests fetch
+// from upstream instead of serving data from before an outage. It reports
+// how many forecasts were evicted. Since the API token is what guards it,
+// it refuses to run on a server without one.
+func (s *Server) HandleFlushCache(w http.ResponseWriter, r *http.Request) {
+	if s.APIToken == "" {
+		writeJSONError(w, r, http.StatusForbidden, "admin_disabled", "Admin endpoints require an API token to be configured")
+		return
+	}
+	n := s.cache.flush()
+	slog.InfoContext(r.Context(), "flushed forecast cache", "evicted", n)
+	writeJSON(w, r, struct {
+		Evicted int `json:"evicted"`
+	}{n})
+}
+
+// forcedRefreshInterval is how often each forecast may be refetched on
+// request, so forced refreshes can't defeat the cache.
+const forcedRefreshInterval = 30 * time.Second
+
+// forceRefresh reports whether r asks to bypass the cache, with ?refresh=1
+// or Cache-Control: no-cache, and q hasn't been force-refreshed within
+// forcedRefreshInterval. Over the limit the request is served as usual.
+func (s *Server) forceRefresh(r *http.Request, q forecastQuery) bool {
+	asked := r.URL.Query().Get("refresh") == "1" || strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache")
+	if !asked {
+		return false
+	}
+	ok, _ := s.forcedRefreshes.allow(fmt.Sprint(q), s.Now(), 1/forcedRefreshInterval.Seconds(), 1)
+	return ok
+}
+
+// startRefresher refreshes the default forecast, and every other cached
+// one still in use, immediately and then every interval until ctx is
+// done. The returned channel is closed once the goroutine has exited.
+func (s *Server) startRefresher(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	s.refreshing.Store(true)
+	go func() {
+		defer close(done)
+		defer s.refreshing.Store(false)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.refreshAll(ctx, max(s.CacheTTL, interval))
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
+
+// refreshAll refreshes the default forecast and every cached one looked up
+// within idle, first evicting the rest. Otherwise a client could make the
+// server refetch hundreds of places nobody asks for, forever.
+func (s *Server) refreshAll(ctx context.Context, idle time.Duration) {
+	if n := s.cache.evictIdle(s.Now().Add(-idle)); n > 0 {
+		slog.DebugContext(ctx, "evicted idle forecasts", "count", n)
+	}
+	keys := s.cache.keys()
+	if def := s.defaultQuery(Imperial); !slices.Contains(keys, def) {
+		keys = append(keys, def)
+	}
+	for _, q := range keys {
+		if _, err := s.refreshForecast(ctx, q); err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, "background refresh", "lat", q.Lat, "lon", q.Lon, "units", q.Units, "error", err)
+		}
+	}
+}
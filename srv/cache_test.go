@@ -0,0 +1,308 @@
+package srv
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchWeatherCaches(t *testing.T) {
+	server, hits := newStubServer(t, stubForecastJSON)
+
+	for i := 0; i < 2; i++ {
+		forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+		if err != nil {
+			t.Fatalf("fetch %d: %v", i, err)
+		}
+		if forecast.Current.Temperature != 41.3 {
+			t.Errorf("fetch %d: expected temperature 41.3, got %v", i, forecast.Current.Temperature)
+		}
+		if len(forecast.Hourly) != 3 {
+			t.Errorf("fetch %d: expected 3 hourly entries, got %d", i, len(forecast.Hourly))
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("expected 1 upstream request, got %d", n)
+	}
+}
+
+func TestFetchWeatherCacheExpires(t *testing.T) {
+	server, hits := newStubServer(t, stubForecastJSON, WithCacheTTL(time.Minute))
+
+	for i, step := range []time.Duration{59 * time.Second, time.Second, 0} {
+		if _, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial)); err != nil {
+			t.Fatalf("fetch %d: %v", i, err)
+		}
+		advanceClock(server, step)
+	}
+	// The first fetch is reused at 59s and expires at 60s.
+	if n := hits.Load(); n != 2 {
+		t.Errorf("expected 2 upstream requests, got %d", n)
+	}
+}
+
+func TestFetchWeatherCachesPerUnitSystem(t *testing.T) {
+	server, hits := newStubServer(t, stubForecastJSON)
+
+	for _, units := range []UnitSystem{Imperial, Metric, Imperial, Metric} {
+		if _, err := server.fetchWeather(context.Background(), server.defaultQuery(units)); err != nil {
+			t.Fatalf("fetch %s: %v", units, err)
+		}
+	}
+	if n := hits.Load(); n != 2 {
+		t.Errorf("expected 2 upstream requests, got %d", n)
+	}
+}
+
+func TestFetchWeatherCachesPerLocation(t *testing.T) {
+	server, hits := newStubServer(t, stubForecastJSON)
+	ctx := context.Background()
+
+	paris := newForecastQuery(48.8566, 2.3522, Imperial)
+	for _, q := range []forecastQuery{
+		server.defaultQuery(Imperial),
+		paris,
+		server.defaultQuery(Imperial),
+		// Rounds to the same 4 decimals as paris.
+		newForecastQuery(48.85661, 2.35219, Imperial),
+	} {
+		if _, err := server.fetchWeather(ctx, q); err != nil {
+			t.Fatalf("fetch %+v: %v", q, err)
+		}
+	}
+	if n := hits.Load(); n != 2 {
+		t.Errorf("expected 2 upstream requests, got %d", n)
+	}
+	if keys := server.cache.keys(); len(keys) != 2 {
+		t.Errorf("expected 2 cache entries, got %v", keys)
+	}
+}
+
+func TestWeatherCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := weatherCache{max: 2}
+	a := newForecastQuery(1, 1, Imperial)
+	b := newForecastQuery(2, 2, Imperial)
+	d := newForecastQuery(3, 3, Imperial)
+	now := time.Now()
+
+	c.set(a, &Forecast{}, now)
+	c.set(b, &Forecast{}, now)
+	c.get(a, now) // b is now the least recently used
+	c.set(d, &Forecast{}, now)
+	if _, _, ok := c.get(b, now); ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, q := range []forecastQuery{a, d} {
+		if _, _, ok := c.get(q, now); !ok {
+			t.Errorf("expected %+v to be kept", q)
+		}
+	}
+
+	// Refreshing an entry replaces it without counting as a use.
+	c.get(a, now)
+	refreshed := &Forecast{}
+	c.set(d, refreshed, now.Add(time.Minute))
+	if got, fetchedAt, _ := c.get(d, now); got != refreshed || !fetchedAt.Equal(now.Add(time.Minute)) {
+		t.Error("expected set to replace the cached forecast")
+	}
+	c.set(b, &Forecast{}, now)
+	if _, _, ok := c.get(a, now); ok {
+		t.Error("expected a, used before d was last read, to be evicted")
+	}
+	if n := len(c.keys()); n != 2 {
+		t.Errorf("expected the cache to stay at 2 entries, got %d", n)
+	}
+}
+
+func TestForcedRefresh(t *testing.T) {
+	for _, path := range []string{"/", "/api/weather"} {
+		t.Run(path, func(t *testing.T) {
+			server, hits := newStubServer(t, stubForecastJSON)
+			handler := server.routes()
+			get := func(url, cacheControl string) {
+				t.Helper()
+				req := httptest.NewRequest(http.MethodGet, url, nil)
+				if cacheControl != "" {
+					req.Header.Set("Cache-Control", cacheControl)
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				if w.Code != http.StatusOK {
+					t.Fatalf("GET %s: expected status 200, got %d", url, w.Code)
+				}
+			}
+
+			get(path, "")
+			get(path, "")
+			if n := hits.Load(); n != 1 {
+				t.Fatalf("expected a normal request to use the cache, got %d upstream requests", n)
+			}
+			get(path+"?refresh=1", "")
+			if n := hits.Load(); n != 2 {
+				t.Fatalf("expected ?refresh=1 to fetch, got %d upstream requests", n)
+			}
+
+			// A second forced refresh within the interval is served from
+			// the cache.
+			get(path+"?refresh=1", "")
+			get(path, "no-cache")
+			if n := hits.Load(); n != 2 {
+				t.Errorf("expected forced refreshes to be limited, got %d upstream requests", n)
+			}
+
+			advanceClock(server, forcedRefreshInterval)
+			get(path, "no-cache")
+			if n := hits.Load(); n != 3 {
+				t.Errorf("expected Cache-Control: no-cache to fetch after the interval, got %d upstream requests", n)
+			}
+		})
+	}
+}
+
+func TestRefresher(t *testing.T) {
+	server, hits := newStubServer(t, stubForecastJSON, WithCacheTTL(time.Nanosecond))
+	if _, err := server.fetchWeather(context.Background(), server.defaultQuery(Metric)); err != nil {
+		t.Fatalf("warm metric cache: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := server.startRefresher(ctx, time.Hour)
+
+	// The first pass runs immediately and covers the default and every cached key.
+	deadline := time.Now().Add(5 * time.Second)
+	for hits.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("refresher made %d upstream requests, expected 3", hits.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// With the refresher running, handlers serve the cache even past its TTL.
+	for _, units := range []UnitSystem{Imperial, Metric, Imperial} {
+		if _, err := server.fetchWeather(context.Background(), server.defaultQuery(units)); err != nil {
+			t.Fatalf("fetch %s: %v", units, err)
+		}
+	}
+	if n := hits.Load(); n != 3 {
+		t.Errorf("expected handlers to be served from cache, got %d upstream requests", n)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresher did not stop after cancel")
+	}
+	if server.refreshing.Load() {
+		t.Error("expected refreshing flag to be cleared after stop")
+	}
+}
+
+func TestRefreshAllSkipsIdleForecasts(t *testing.T) {
+	server, hits := newStubServer(t, stubForecastJSON)
+	ctx := context.Background()
+	idle := 5 * time.Minute
+	used, seeded := server.defaultQuery(Metric), newForecastQuery(48.8566, 2.3522, Imperial)
+	for _, q := range []forecastQuery{server.defaultQuery(Imperial), used, seeded} {
+		if _, err := server.fetchWeather(ctx, q); err != nil {
+			t.Fatalf("warm cache: %v", err)
+		}
+	}
+
+	// Only used is looked up again before the next pass.
+	advanceClock(server, 3*time.Minute)
+	server.cache.get(used, server.Now())
+	advanceClock(server, 3*time.Minute)
+	hits.Store(0)
+	server.refreshAll(ctx, idle)
+
+	if n := hits.Load(); n != 2 {
+		t.Errorf("expected the default and the used forecast refreshed, got %d upstream requests", n)
+	}
+	keys := server.cache.keys()
+	if len(keys) != 2 || slices.Contains(keys, seeded) {
+		t.Errorf("expected the idle forecast evicted, got %v", keys)
+	}
+
+	// A refresh doesn't count as a use, so unused forecasts age out.
+	advanceClock(server, 6*time.Minute)
+	hits.Store(0)
+	server.refreshAll(ctx, idle)
+	if keys := server.cache.keys(); len(keys) != 1 || keys[0] != server.defaultQuery(Imperial) {
+		t.Errorf("expected only the default forecast left, got %v", keys)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("expected only the default forecast refreshed, got %d upstream requests", n)
+	}
+}
+
+func TestHandleFlushCache(t *testing.T) {
+	server, hits := newStubServer(t, stubForecastJSON, WithAPIToken("s3cret"))
+	handler := server.routes()
+	do := func(t *testing.T, method, target, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, target := range []string{"/api/weather", "/api/weather?units=metric", "/api/weather"} {
+		if w := do(t, http.MethodGet, target, "s3cret"); w.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected status 200, got %d", target, w.Code)
+		}
+	}
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("expected 2 upstream requests to fill the cache, got %d", n)
+	}
+
+	if w := do(t, http.MethodPost, "/admin/cache/flush", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without the token, got %d", w.Code)
+	}
+	w := do(t, http.MethodPost, "/admin/cache/flush", "s3cret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	if body := strings.TrimSpace(w.Body.String()); body != `{"evicted":2}` {
+		t.Errorf("expected 2 evicted forecasts, got %s", body)
+	}
+	if etag := w.Header().Get("ETag"); etag != "" {
+		t.Errorf("expected no ETag on a POST response, got %s", etag)
+	}
+	if keys := server.cache.keys(); len(keys) != 0 {
+		t.Errorf("expected an empty cache, got %v", keys)
+	}
+
+	// A retry that sends If-None-Match must still get the count.
+	r := httptest.NewRequest(http.MethodPost, "/admin/cache/flush", nil)
+	r.Header.Set("Authorization", "Bearer s3cret")
+	r.Header.Set("If-None-Match", "*")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if body := strings.TrimSpace(w.Body.String()); w.Code != http.StatusOK || body != `{"evicted":0}` {
+		t.Errorf("expected a retried flush to report 0 evicted, got %d %s", w.Code, body)
+	}
+
+	if w := do(t, http.MethodGet, "/api/weather", "s3cret"); w.Code != http.StatusOK {
+		t.Fatalf("GET after flush: expected status 200, got %d", w.Code)
+	}
+	if n := hits.Load(); n != 3 {
+		t.Errorf("expected the flush to force an upstream request, got %d requests", n)
+	}
+
+	t.Run("no token configured", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON)
+		w := httptest.NewRecorder()
+		server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/cache/flush", nil))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected status 403 when the server has no token, got %d", w.Code)
+		}
+	})
+}
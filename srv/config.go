@@ -0,0 +1,149 @@
+package srv
+
+import (
+	"fmt"
+	"time"
+)
+
+// Config holds a Server's settings. Start from DefaultConfig and change
+// what you need; NewWithConfig checks the result.
+type Config struct {
+	// DBPath is the sqlite database file.
+	DBPath string
+	// Hostname is shown on the page. Empty means the system hostname.
+	Hostname string
+	// TemplatesDir and StaticDir, if set, replace the embedded assets.
+	TemplatesDir string
+	StaticDir    string
+	// DevMode reads assets from the source tree and disables browser
+	// caching of static files.
+	DevMode bool
+
+	// LocationName, Lat and Lon are the default location.
+	LocationName string
+	Lat          float64
+	Lon          float64
+	// Timezone is the IANA name forecast times are shown in.
+	Timezone string
+
+	// CacheTTL is how long a forecast is reused before refetching.
+	CacheTTL time.Duration
+	// RefreshInterval is how often Serve refreshes forecasts in the
+	// background. Forecasts not looked up within the larger of it and
+	// CacheTTL are evicted instead.
+	RefreshInterval time.Duration
+	// StaleOK and StaleMax bound the age of forecasts the JSON API serves;
+	// see WithStaleLimits.
+	StaleOK  time.Duration
+	StaleMax time.Duration
+	// FetchTimeout bounds each upstream request made by the default HTTP
+	// client.
+	FetchTimeout time.Duration
+	// ForecastURL, AirQualityURL and GeocodingURL are the Open-Meteo
+	// compatible endpoints.
+	ForecastURL   string
+	AirQualityURL string
+	GeocodingURL  string
+	// UserAgent is sent with every upstream request.
+	UserAgent string
+	// MaxRetries is how many times a failed upstream request is retried,
+	// by default after exponential backoff from RetryBaseDelay.
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+	// ForecastHours is how many hourly entries are requested by default.
+	ForecastHours int
+
+	// ContentSecurityPolicy is sent with every response, without
+	// frame-ancestors, which the server adds. Empty sends none.
+	ContentSecurityPolicy string
+
+	// AllowedOrigins may call the JSON API from a browser; "*" is any.
+	AllowedOrigins []string
+	// APIToken, if set, must be sent as a bearer token to the JSON API.
+	APIToken string
+	// RateLimit is requests per second per client IP on the weather
+	// endpoints, with bursts of RateBurst. Zero disables limiting.
+	RateLimit float64
+	RateBurst int
+	// TrustForwardedFor takes client IPs from X-Forwarded-For, as set by
+	// TrustedProxies reverse proxies.
+	TrustForwardedFor bool
+	TrustedProxies    int
+	// StaticMaxAge is the Cache-Control max-age for static files, in
+	// seconds.
+	StaticMaxAge int
+}
+
+// DefaultConfig returns the settings New starts from: Brooklyn, NY, live
+// Open-Meteo endpoints, and the embedded assets.
+func DefaultConfig() Config {
+	return Config{
+		DBPath:                "db.sqlite3",
+		LocationName:          brooklynName,
+		Lat:                   brooklynLat,
+		Lon:                   brooklynLon,
+		Timezone:              defaultTimezone,
+		CacheTTL:              defaultCacheTTL,
+		RefreshInterval:       defaultCacheTTL,
+		StaleOK:               defaultStaleOK,
+		StaleMax:              defaultStaleMax,
+		FetchTimeout:          defaultFetchTimeout,
+		UserAgent:             defaultUserAgent(),
+		MaxRetries:            defaultMaxRetries,
+		RetryBaseDelay:        defaultRetryBaseDelay,
+		ForecastURL:           openMeteoForecastURL,
+		AirQualityURL:         openMeteoAirQualityURL,
+		GeocodingURL:          openMeteoGeocodingURL,
+		ForecastHours:         defaultForecastHours,
+		AllowedOrigins:        []string{"*"},
+		ContentSecurityPolicy: defaultContentSecurityPolicy,
+		RateLimit:             defaultRateLimit,
+		RateBurst:             defaultRateBurst,
+		TrustedProxies:        1,
+		StaticMaxAge:          defaultStaticMaxAge,
+	}
+}
+
+// check validates c, normalizing Hostname on the way.
+func (c *Config) check() error {
+	hostname, err := normalizeHostname(c.Hostname)
+	if err != nil {
+		return err
+	}
+	c.Hostname = hostname
+	if err := validateCoordinates(c.Lat, c.Lon); err != nil {
+		return err
+	}
+	// "Local" means this machine's zone, which Open-Meteo can't know.
+	if c.Timezone == "" || c.Timezone == "Local" {
+		return fmt.Errorf("timezone must be an IANA name like %q, got %q", defaultTimezone, c.Timezone)
+	}
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		return fmt.Errorf("load timezone: %w", err)
+	}
+	if c.RateLimit < 0 || (c.RateLimit > 0 && c.RateBurst < 1) {
+		return fmt.Errorf("invalid rate limit %v/s with burst %d", c.RateLimit, c.RateBurst)
+	}
+	if c.TrustedProxies < 1 {
+		return fmt.Errorf("trusted proxies must be at least 1, got %d", c.TrustedProxies)
+	}
+	if c.StaticMaxAge < 0 {
+		return fmt.Errorf("static max-age must not be negative, got %d", c.StaticMaxAge)
+	}
+	if c.FetchTimeout <= 0 {
+		return fmt.Errorf("fetch timeout must be positive, got %v", c.FetchTimeout)
+	}
+	if c.MaxRetries < 0 || c.RetryBaseDelay < 0 {
+		return fmt.Errorf("invalid retries %d with base delay %v", c.MaxRetries, c.RetryBaseDelay)
+	}
+	if c.ForecastHours < 1 || c.ForecastHours > maxForecastHours {
+		return fmt.Errorf("forecast hours %d out of range [1, %d]", c.ForecastHours, maxForecastHours)
+	}
+	if c.StaleOK <= 0 || c.StaleMax < c.StaleOK {
+		return fmt.Errorf("stale limits must satisfy 0 < ok <= max, got ok %v, max %v", c.StaleOK, c.StaleMax)
+	}
+	if c.RefreshInterval <= 0 {
+		return fmt.Errorf("refresh interval must be positive, got %v", c.RefreshInterval)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package srv
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDefaultConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Hostname = "test-hostname"
+	if err := cfg.check(); err != nil {
+		t.Fatalf("default config should be valid: %v", err)
+	}
+}
+
+func TestNewWithConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DBPath = filepath.Join(t.TempDir(), "db.sqlite3")
+	cfg.Hostname = "test-hostname"
+	cfg.LocationName, cfg.Lat, cfg.Lon = "Paris", 48.8566, 2.3522
+	cfg.Timezone = "Europe/Paris"
+	cfg.FetchTimeout = 3 * time.Second
+
+	server, err := NewWithConfig(cfg, WithAPIToken("secret"))
+	if err != nil {
+		t.Fatalf("NewWithConfig: %v", err)
+	}
+	if server.LocationName != "Paris" || server.Lat != 48.8566 || server.Lon != 2.3522 {
+		t.Errorf("location not applied: %+v", server.Config)
+	}
+	if server.timezone.String() != "Europe/Paris" {
+		t.Errorf("expected Europe/Paris, got %v", server.timezone)
+	}
+	if server.HTTPClient.Timeout != 3*time.Second {
+		t.Errorf("expected a 3s client timeout, got %v", server.HTTPClient.Timeout)
+	}
+	if server.APIToken != "secret" {
+		t.Errorf("options should apply on top of the config, got token %q", server.APIToken)
+	}
+}
+
+func TestConfigCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+	}{
+		{"latitude", func(c *Config) { c.Lat = 91 }},
+		{"empty timezone", func(c *Config) { c.Timezone = "" }},
+		{"unknown timezone", func(c *Config) { c.Timezone = "Mars/Olympus_Mons" }},
+		{"negative rate limit", func(c *Config) { c.RateLimit = -1 }},
+		{"zero burst", func(c *Config) { c.RateLimit, c.RateBurst = 1, 0 }},
+		{"trusted proxies", func(c *Config) { c.TrustedProxies = 0 }},
+		{"static max-age", func(c *Config) { c.StaticMaxAge = -1 }},
+		{"fetch timeout", func(c *Config) { c.FetchTimeout = 0 }},
+		{"negative retries", func(c *Config) { c.MaxRetries = -1 }},
+		{"negative retry delay", func(c *Config) { c.RetryBaseDelay = -time.Second }},
+		{"forecast hours", func(c *Config) { c.ForecastHours = maxForecastHours + 1 }},
+		{"stale limits", func(c *Config) { c.StaleOK, c.StaleMax = time.Hour, time.Minute }},
+		{"refresh interval", func(c *Config) { c.RefreshInterval = 0 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.DBPath = filepath.Join(t.TempDir(), "db.sqlite3")
+			cfg.Hostname = "test-hostname"
+			tt.modify(&cfg)
+			if _, err := NewWithConfig(cfg); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package srv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const openMeteoGeocodingURL = "https://geocoding-api.open-meteo.com/v1/search"
+
+// maxGeocodeCacheEntries bounds the geocoding cache, since its keys come
+// straight from user input.
+const maxGeocodeCacheEntries = 1000
+
+var (
+	// errEmptyPlaceQuery is returned for a ?q= with nothing to search for.
+	errEmptyPlaceQuery = errors.New("q must not be empty")
+	// errUnknownPlace is returned when geocoding finds no match.
+	errUnknownPlace = errors.New("no place matches q")
+	// errGeocodingUnavailable wraps failures to reach the geocoding API.
+	errGeocodingUnavailable = errors.New("geocoding unavailable")
+)
+
+// place is a geocoding result.
+type place struct {
+	Name string
+	Lat  float64
+	Lon  float64
+}
+
+// Open-Meteo geocoding API response structure
+type openMeteoGeocodingResponse struct {
+	Results []struct {
+		Name      string  `json:"name"`
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+		Admin1    string  `json:"admin1"`
+		Country   string  `json:"country"`
+	} `json:"results"`
+}
+
+// geocodeCache holds resolved place queries, keyed by normalized query,
+// because place names don't move. It is safe for concurrent use.
+type geocodeCache struct {
+	mu      sync.Mutex
+	entries map[string]place
+}
+
+func (c *geocodeCache) get(key string) (place, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.entries[key]
+	return p, ok
+}
+
+func (c *geocodeCache) set(key string, p place) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]place)
+	}
+	if len(c.entries) >= maxGeocodeCacheEntries {
+		// Evict an arbitrary entry; a miss only costs one lookup.
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = p
+}
+
+// WithGeocodingURL sets the Open-Meteo compatible geocoding endpoint.
+func WithGeocodingURL(u string) Option {
+	return func(s *Server) {
+		s.GeocodingURL = u
+	}
+}
+
+// geocode resolves a place name or postal code, like "Brooklyn" or
+// "10001", to its top match. It returns errUnknownPlace if nothing
+// matches.
+func (s *Server) geocode(ctx context.Context, query string) (place, error) {
+	key := strings.ToLower(strings.Join(strings.Fields(query), " "))
+	if key == "" {
+		return place{}, errEmptyPlaceQuery
+	}
+	if p, ok := s.geocodes.get(key); ok {
+		return p, nil
+	}
+
+	params := url.Values{}
+	params.Set("name", key)
+	params.Set("count", "1")
+	params.Set("language", "en")
+	params.Set("format", "json")
+	resp, err := s.getWithRetry(ctx, s.GeocodingURL+"?"+params.Encode())
+	if err != nil {
+		return place{}, fmt.Errorf("%w: %w", errGeocodingUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return place{}, fmt.Errorf("%w: %w", errGeocodingUnavailable, newUpstreamError(resp))
+	}
+
+	var data openMeteoGeocodingResponse
+	if err := decodeUpstream(resp.Body, &data); err != nil {
+		return place{}, fmt.Errorf("%w: decode geocoding: %w", errGeocodingUnavailable, err)
+	}
+	if len(data.Results) == 0 {
+		return place{}, errUnknownPlace
+	}
+	top := data.Results[0]
+	p := place{
+		Name: placeName(top.Name, top.Admin1, top.Country),
+		Lat:  top.Latitude,
+		Lon:  top.Longitude,
+	}
+	s.geocodes.set(key, p)
+	return p, nil
+}
+
+// placeName joins the non-empty parts of a place's name, skipping repeats
+// like "Singapore, Singapore".
+func placeName(parts ...string) string {
+	var name []string
+	for _, part := range parts {
+		if part != "" && (len(name) == 0 || name[len(name)-1] != part) {
+			name = append(name, part)
+		}
+	}
+	return strings.Join(name, ", ")
+}
@@ -0,0 +1,156 @@
+package srv
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const stubGeocodingJSON = `{
+  "results": [
+    {"name": "Brooklyn", "latitude": 40.6501, "longitude": -73.94958, "admin1": "New York", "country": "United States"},
+    {"name": "Brooklyn", "latitude": 41.9995, "longitude": -83.3880, "admin1": "Michigan", "country": "United States"}
+  ]
+}`
+
+// newGeocodingStub serves body for every geocoding request and records the
+// last ?name= searched for.
+func newGeocodingStub(t *testing.T, status int, body string) (url string, hits *atomic.Int64, lastName *atomic.Value) {
+	t.Helper()
+	hits, lastName = new(atomic.Int64), new(atomic.Value)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		lastName.Store(r.URL.Query().Get("name"))
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream.URL, hits, lastName
+}
+
+func TestGeocode(t *testing.T) {
+	url, hits, lastName := newGeocodingStub(t, http.StatusOK, stubGeocodingJSON)
+	server, _ := newStubServer(t, stubForecastJSON, WithGeocodingURL(url))
+
+	p, err := server.geocode(context.Background(), "  Brooklyn ")
+	if err != nil {
+		t.Fatalf("geocode: %v", err)
+	}
+	want := place{Name: "Brooklyn, New York, United States", Lat: 40.6501, Lon: -73.94958}
+	if p != want {
+		t.Errorf("geocode = %+v, want the top match %+v", p, want)
+	}
+	if got := lastName.Load(); got != "brooklyn" {
+		t.Errorf("expected a normalized search for brooklyn, got %q", got)
+	}
+
+	if p, err := server.geocode(context.Background(), "BROOKLYN"); err != nil || p != want {
+		t.Errorf("second geocode = %+v, %v; want %+v", p, err, want)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("expected the second lookup to be cached, got %d upstream requests", n)
+	}
+
+	if _, err := server.geocode(context.Background(), " \t"); !errors.Is(err, errEmptyPlaceQuery) {
+		t.Errorf("expected errEmptyPlaceQuery for a blank query, got %v", err)
+	}
+}
+
+func TestGeocodeFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"no match", http.StatusOK, `{"generationtime_ms": 0.5}`, errUnknownPlace},
+		{"upstream error", http.StatusBadRequest, `{"error": true}`, errGeocodingUnavailable},
+		{"bad body", http.StatusOK, `{`, errGeocodingUnavailable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			url, _, _ := newGeocodingStub(t, test.status, test.body)
+			server, _ := newStubServer(t, stubForecastJSON, WithGeocodingURL(url))
+			if _, err := server.geocode(context.Background(), "Atlantis"); !errors.Is(err, test.want) {
+				t.Errorf("expected %v, got %v", test.want, err)
+			}
+		})
+	}
+}
+
+func TestGeocodeCacheBounded(t *testing.T) {
+	var c geocodeCache
+	for i := range maxGeocodeCacheEntries + 10 {
+		c.set(strings.Repeat("x", i+1), place{})
+	}
+	if n := len(c.entries); n != maxGeocodeCacheEntries {
+		t.Errorf("expected the cache to hold %d entries, got %d", maxGeocodeCacheEntries, n)
+	}
+}
+
+func TestPlaceQueryRequests(t *testing.T) {
+	var gotLat string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotLat = r.URL.Query().Get("latitude")
+		w.Write([]byte(stubForecastJSON))
+	}))
+	defer upstream.Close()
+	url, _, _ := newGeocodingStub(t, http.StatusOK, stubGeocodingJSON)
+	server, _ := newStubServer(t, stubForecastJSON, WithForecastURL(upstream.URL), WithGeocodingURL(url))
+	handler := server.routes()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?q=Brooklyn", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "Brooklyn, New York, United States") {
+		t.Errorf("expected the resolved place name on the page, got body: %s", body)
+	}
+	if !strings.Contains(body, `href="?q=Brooklyn&amp;units=metric"`) {
+		t.Errorf("expected the units toggle to keep q, got body: %s", body)
+	}
+	if gotLat != "40.6501" {
+		t.Errorf("expected the forecast for the geocoded latitude, got %q", gotLat)
+	}
+
+	for path, status := range map[string]int{
+		"/?q=":                    http.StatusBadRequest,
+		"/api/weather?q=":         http.StatusBadRequest,
+		"/api/weather?q=Brooklyn": http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != status {
+			t.Errorf("%s: expected status %d, got %d", path, status, w.Code)
+		}
+	}
+
+	url, _, _ = newGeocodingStub(t, http.StatusOK, `{}`)
+	server.GeocodingURL = url
+	for _, path := range []string{"/?q=Atlantis", "/api/weather?q=Atlantis"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status 404, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestPlaceName(t *testing.T) {
+	tests := map[string][]string{
+		"Brooklyn, New York, United States": {"Brooklyn", "New York", "United States"},
+		"Singapore":                         {"Singapore", "Singapore", "Singapore"},
+		"Null Island":                       {"Null Island", "", ""},
+	}
+	for want, parts := range tests {
+		if got := placeName(parts...); got != want {
+			t.Errorf("placeName(%q) = %q, want %q", parts, got, want)
+		}
+	}
+}
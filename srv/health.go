@@ -0,0 +1,37 @@
+package srv
+
+import (
+	"sync"
+	"time"
+)
+
+// degradedAfterFailures is how many upstream fetches in a row must fail
+// before /healthz reports the server as degraded.
+const degradedAfterFailures = 3
+
+// fetchHealth tracks recent upstream fetch outcomes for /healthz. It is
+// safe for concurrent use.
+type fetchHealth struct {
+	mu          sync.Mutex
+	failures    int // consecutive, since the last success
+	lastSuccess time.Time
+}
+
+func (h *fetchHealth) observe(now time.Time, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.failures++
+		return
+	}
+	h.failures = 0
+	h.lastSuccess = now
+}
+
+// snapshot returns the number of consecutive failures and the time of the
+// last success, which is zero if there hasn't been one.
+func (h *fetchHealth) snapshot() (failures int, lastSuccess time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.failures, h.lastSuccess
+}
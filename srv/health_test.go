@@ -0,0 +1,27 @@
+package srv
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFetchHealth(t *testing.T) {
+	var h fetchHealth
+	if failures, last := h.snapshot(); failures != 0 || !last.IsZero() {
+		t.Errorf("expected a clean start, got %d failures, last success %v", failures, last)
+	}
+
+	errUpstream := errors.New("upstream down")
+	h.observe(stubNow, nil)
+	h.observe(stubNow.Add(time.Minute), errUpstream)
+	h.observe(stubNow.Add(2*time.Minute), errUpstream)
+	if failures, last := h.snapshot(); failures != 2 || !last.Equal(stubNow) {
+		t.Errorf("expected 2 failures since %v, got %d since %v", stubNow, failures, last)
+	}
+
+	h.observe(stubNow.Add(3*time.Minute), nil)
+	if failures, last := h.snapshot(); failures != 0 || !last.Equal(stubNow.Add(3*time.Minute)) {
+		t.Errorf("expected a success to reset the count, got %d failures, last success %v", failures, last)
+	}
+}
@@ -0,0 +1,134 @@
+package srv
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage is used when a request doesn't ask for a supported one.
+const defaultLanguage = "en"
+
+// conditionTranslations maps each language to the display text for the
+// condition keys returned by weatherCodeToCondition.
+var conditionTranslations = map[string]map[string]string{
+	"en": {
+		"clear_sky":         "Clear sky",
+		"mainly_clear":      "Mainly clear",
+		"partly_cloudy":     "Partly cloudy",
+		"overcast":          "Overcast",
+		"fog":               "Foggy",
+		"drizzle":           "Drizzle",
+		"freezing_drizzle":  "Freezing drizzle",
+		"rain":              "Rain",
+		"freezing_rain":     "Freezing rain",
+		"snow":              "Snow",
+		"snow_grains":       "Snow grains",
+		"rain_showers":      "Rain showers",
+		"snow_showers":      "Snow showers",
+		"thunderstorm":      "Thunderstorm",
+		"thunderstorm_hail": "Thunderstorm with hail",
+		"unknown":           "Unknown",
+	},
+	"es": {
+		"clear_sky":         "Cielo despejado",
+		"mainly_clear":      "Mayormente despejado",
+		"partly_cloudy":     "Parcialmente nublado",
+		"overcast":          "Nublado",
+		"fog":               "Niebla",
+		"drizzle":           "Llovizna",
+		"freezing_drizzle":  "Llovizna helada",
+		"rain":              "Lluvia",
+		"freezing_rain":     "Lluvia helada",
+		"snow":              "Nieve",
+		"snow_grains":       "Cinarra",
+		"rain_showers":      "Chubascos",
+		"snow_showers":      "Chubascos de nieve",
+		"thunderstorm":      "Tormenta",
+		"thunderstorm_hail": "Tormenta con granizo",
+		"unknown":           "Desconocido",
+	},
+	"fr": {
+		"clear_sky":         "Ciel dégagé",
+		"mainly_clear":      "Plutôt dégagé",
+		"partly_cloudy":     "Partiellement nuageux",
+		"overcast":          "Couvert",
+		"fog":               "Brouillard",
+		"drizzle":           "Bruine",
+		"freezing_drizzle":  "Bruine verglaçante",
+		"rain":              "Pluie",
+		"freezing_rain":     "Pluie verglaçante",
+		"snow":              "Neige",
+		"snow_grains":       "Neige en grains",
+		"rain_showers":      "Averses",
+		"snow_showers":      "Averses de neige",
+		"thunderstorm":      "Orage",
+		"thunderstorm_hail": "Orage avec grêle",
+		"unknown":           "Inconnu",
+	},
+}
+
+// translateCondition returns the display text for a condition key in
+// lang, falling back to English and then to the key itself.
+func translateCondition(lang, key string) string {
+	if text, ok := conditionTranslations[lang][key]; ok {
+		return text
+	}
+	if text, ok := conditionTranslations[defaultLanguage][key]; ok {
+		return text
+	}
+	return key
+}
+
+// requestLanguage picks the display language from ?lang=, then from the
+// highest-weighted supported Accept-Language entry, defaulting to English.
+// Regional variants like "es-MX" match their base language.
+func requestLanguage(r *http.Request) string {
+	if lang := baseLanguage(r.URL.Query().Get("lang")); conditionTranslations[lang] != nil {
+		return lang
+	}
+	best, bestQ := defaultLanguage, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if lang := baseLanguage(tag); conditionTranslations[lang] != nil && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// baseLanguage lowercases a language tag and strips any region, so
+// "fr-CA" becomes "fr".
+func baseLanguage(tag string) string {
+	base, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+	return strings.ToLower(base)
+}
+
+// localizeCurrent returns a copy of w with its condition translated into
+// lang. Cached forecasts are shared, so they are never modified in place.
+func localizeCurrent(w *WeatherData, lang string) *WeatherData {
+	if w == nil {
+		return nil
+	}
+	localized := *w
+	localized.Condition = translateCondition(lang, w.Condition)
+	return &localized
+}
+
+// localizeDaily returns a copy of days with their conditions translated
+// into lang.
+func localizeDaily(days []DailyForecast, lang string) []DailyForecast {
+	localized := make([]DailyForecast, len(days))
+	for i, d := range days {
+		d.Condition = translateCondition(lang, d.Condition)
+		localized[i] = d
+	}
+	return localized
+}
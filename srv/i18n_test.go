@@ -0,0 +1,88 @@
+package srv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTranslateConditionSpanish(t *testing.T) {
+	key, _ := weatherCodeToCondition(61, true)
+	if got := translateCondition("es", key); got != "Lluvia" {
+		t.Errorf("translateCondition(es, %q) = %q, expected %q", key, got, "Lluvia")
+	}
+	if got := translateCondition("en", key); got != "Rain" {
+		t.Errorf("translateCondition(en, %q) = %q, expected %q", key, got, "Rain")
+	}
+}
+
+func TestTranslationsComplete(t *testing.T) {
+	for code := 0; code <= 99; code++ {
+		key, _ := weatherCodeToCondition(code, true)
+		for lang, translations := range conditionTranslations {
+			if _, ok := translations[key]; !ok {
+				t.Errorf("%s: no translation for %q (code %d)", lang, key, code)
+			}
+		}
+	}
+}
+
+func TestRequestLanguage(t *testing.T) {
+	tests := []struct {
+		query          string
+		acceptLanguage string
+		expected       string
+	}{
+		{"", "", "en"},
+		{"?lang=fr", "", "fr"},
+		{"?lang=ES", "fr", "es"},
+		{"?lang=de", "es", "es"},
+		{"", "es-MX,es;q=0.9,en;q=0.8", "es"},
+		{"", "de-DE, fr-CA;q=0.7, en;q=0.5", "fr"},
+		{"", "en;q=0.4, es;q=0.6", "es"},
+		{"", "de", "en"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/"+test.query, nil)
+		if test.acceptLanguage != "" {
+			req.Header.Set("Accept-Language", test.acceptLanguage)
+		}
+		if got := requestLanguage(req); got != test.expected {
+			t.Errorf("requestLanguage(%q, Accept-Language %q) = %q, expected %q", test.query, test.acceptLanguage, got, test.expected)
+		}
+	}
+}
+
+func TestLocalizedResponses(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+
+	w := httptest.NewRecorder()
+	server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/?lang=es", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `<div class="condition">Nublado</div>`) || !strings.Contains(body, `<html lang="es" `) {
+		t.Errorf("expected a Spanish page, got %s", body)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/weather", nil)
+	req.Header.Set("Accept-Language", "fr-FR")
+	w = httptest.NewRecorder()
+	server.HandleAPI(w, req)
+	var response struct {
+		Current *WeatherData    `json:"current"`
+		Daily   []DailyForecast `json:"daily"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if response.Current.Condition != "Couvert" || response.Daily[1].Condition != "Neige" {
+		t.Errorf("expected French conditions, got %q and %q", response.Current.Condition, response.Daily[1].Condition)
+	}
+
+	// Localizing must not leak into the shared cached forecast.
+	forecast, _, _ := server.cache.get(server.defaultQuery(Imperial), stubNow)
+	if forecast.Current.Condition != "overcast" {
+		t.Errorf("expected the cached condition key to be untouched, got %q", forecast.Current.Condition)
+	}
+}
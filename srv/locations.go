@@ -0,0 +1,109 @@
+package srv
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"srv.exe.dev/db/dbgen"
+)
+
+// maxLocationName bounds the length of a saved location's name.
+const maxLocationName = 100
+
+// defaultQuery returns the forecast query for the server's configured location.
+func (s *Server) defaultQuery(units UnitSystem) forecastQuery {
+	return newForecastQuery(s.Lat, s.Lon, units)
+}
+
+// resolveLocation returns the display name and forecast query for a
+// ?location= value, or for the configured location when name is empty.
+// An unknown name returns sql.ErrNoRows.
+func (s *Server) resolveLocation(ctx context.Context, name string, units UnitSystem) (string, forecastQuery, error) {
+	if name == "" {
+		return s.LocationName, s.defaultQuery(units), nil
+	}
+	loc, err := s.Store.LocationWithName(ctx, name)
+	if err != nil {
+		return "", forecastQuery{}, err
+	}
+	return loc.Name, newForecastQuery(loc.Latitude, loc.Longitude, units), nil
+}
+
+// locationForRequest resolves the location r asks for: a place name or
+// postal code to geocode in ?q=, else a saved ?location=, else the
+// configured location.
+func (s *Server) locationForRequest(r *http.Request, units UnitSystem) (string, forecastQuery, error) {
+	if r.URL.Query().Has("q") {
+		p, err := s.geocode(r.Context(), r.URL.Query().Get("q"))
+		if err != nil {
+			return "", forecastQuery{}, err
+		}
+		return p.Name, newForecastQuery(p.Lat, p.Lon, units), nil
+	}
+	return s.resolveLocation(r.Context(), r.URL.Query().Get("location"), units)
+}
+
+// HandleListLocations returns the saved locations as JSON.
+func (s *Server) HandleListLocations(w http.ResponseWriter, r *http.Request) {
+	locations, err := s.Store.ListLocations(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "list locations", "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, "internal_error", "Unable to load locations")
+		return
+	}
+
+	writeJSON(w, r, locations)
+}
+
+// HandleAddLocation saves a location from a JSON body with name, latitude
+// and longitude. Names must be unique.
+func (s *Server) HandleAddLocation(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name      string   `json:"name"`
+		Latitude  *float64 `json:"latitude"`
+		Longitude *float64 `json:"longitude"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, "invalid_request", "Request body must be a JSON object")
+		return
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" || len(name) > maxLocationName {
+		writeJSONError(w, r, http.StatusBadRequest, "invalid_request", "name must be 1 to 100 characters")
+		return
+	}
+	if body.Latitude == nil || body.Longitude == nil {
+		writeJSONError(w, r, http.StatusBadRequest, "invalid_request", "latitude and longitude are required")
+		return
+	}
+	if err := validateCoordinates(*body.Latitude, *body.Longitude); err != nil {
+		writeJSONError(w, r, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	loc, err := s.Store.AddLocation(r.Context(), dbgen.AddLocationParams{
+		Name:      name,
+		Latitude:  *body.Latitude,
+		Longitude: *body.Longitude,
+		CreatedAt: s.Now().UTC().Truncate(time.Second),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, r, http.StatusConflict, "location_exists", "A location with that name already exists")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "add location", "error", err)
+		writeJSONError(w, r, http.StatusInternalServerError, "internal_error", "Unable to save location")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(loc)
+}
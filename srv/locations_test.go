@@ -0,0 +1,92 @@
+package srv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"srv.exe.dev/db/dbgen"
+)
+
+func TestLocations(t *testing.T) {
+	var gotLat string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotLat = r.URL.Query().Get("latitude")
+		w.Write([]byte(stubForecastJSON))
+	}))
+	defer upstream.Close()
+	server, _ := newStubServer(t, stubForecastJSON, WithForecastURL(upstream.URL))
+	handler := server.routes()
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/locations", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("add and list", func(t *testing.T) {
+		if w := post(`{"name": "Paris", "latitude": 48.8566, "longitude": 2.3522}`); w.Code != http.StatusCreated {
+			t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+		}
+		if w := post(`{"name": "Oslo", "latitude": 59.9139, "longitude": 10.7522}`); w.Code != http.StatusCreated {
+			t.Fatalf("expected status 201, got %d: %s", w.Code, w.Body.String())
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/locations", nil))
+		var locations []dbgen.Location
+		if err := json.Unmarshal(w.Body.Bytes(), &locations); err != nil {
+			t.Fatalf("decode locations: %v", err)
+		}
+		if len(locations) != 2 || locations[0].Name != "Oslo" || locations[1].Name != "Paris" {
+			t.Errorf("unexpected locations: %+v", locations)
+		}
+	})
+
+	t.Run("duplicate name", func(t *testing.T) {
+		if w := post(`{"name": "Paris", "latitude": 1, "longitude": 1}`); w.Code != http.StatusConflict {
+			t.Errorf("expected status 409, got %d", w.Code)
+		}
+	})
+
+	t.Run("malformed input", func(t *testing.T) {
+		for _, body := range []string{
+			`not json`,
+			`{"name": "", "latitude": 1, "longitude": 1}`,
+			`{"name": "Nowhere", "latitude": 1}`,
+			`{"name": "Nowhere", "latitude": 91, "longitude": 1}`,
+			`{"name": "Nowhere", "latitude": 1, "longitude": -200}`,
+		} {
+			if w := post(body); w.Code != http.StatusBadRequest {
+				t.Errorf("%s: expected status 400, got %d", body, w.Code)
+			}
+		}
+	})
+
+	t.Run("root uses saved location", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?location=Paris", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+		if gotLat != "48.8566" {
+			t.Errorf("expected upstream latitude 48.8566, got %q", gotLat)
+		}
+		if !strings.Contains(w.Body.String(), "<h1>Paris</h1>") {
+			t.Errorf("expected location name in page, got body: %s", w.Body.String())
+		}
+	})
+
+	t.Run("unknown location", func(t *testing.T) {
+		for _, path := range []string{"/?location=Atlantis", "/api/weather?location=Atlantis"} {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			if w.Code != http.StatusNotFound {
+				t.Errorf("%s: expected status 404, got %d", path, w.Code)
+			}
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package srv
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// upstreamLatencyBuckets are the histogram bounds, in seconds, for
+// Open-Meteo fetch latency.
+var upstreamLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metrics tracks request and upstream counters for /metrics. It is written
+// out in the Prometheus text exposition format without pulling in a
+// client library.
+type metrics struct {
+	mu               sync.Mutex
+	requests         map[requestKey]uint64
+	upstreamFailures uint64
+	latencyCounts    []uint64 // per bucket, cumulative at render time
+	latencySum       float64
+	latencyCount     uint64
+}
+
+type requestKey struct {
+	path   string
+	status int
+}
+
+func (m *metrics) observeRequest(path string, status int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.requests == nil {
+		m.requests = make(map[requestKey]uint64)
+	}
+	m.requests[requestKey{path, status}]++
+}
+
+func (m *metrics) observeUpstream(d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.latencyCounts == nil {
+		m.latencyCounts = make([]uint64, len(upstreamLatencyBuckets))
+	}
+	seconds := d.Seconds()
+	if i, _ := slices.BinarySearch(upstreamLatencyBuckets, seconds); i < len(upstreamLatencyBuckets) {
+		m.latencyCounts[i]++
+	}
+	m.latencySum += seconds
+	m.latencyCount++
+	if err != nil {
+		m.upstreamFailures++
+	}
+}
+
+// countRequests records each request's route pattern and final status.
+func (s *Server) countRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		// The mux sets r.Pattern; using it rather than the raw path keeps
+		// label cardinality bounded.
+		path := r.Pattern
+		if path == "" {
+			path = "unmatched"
+		}
+		s.metrics.observeRequest(path, rec.status)
+	})
+}
+
+// HandleMetrics serves the collected metrics in Prometheus text format.
+func (s *Server) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	m := &s.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	fmt.Fprintln(w, "# HELP weather_http_requests_total HTTP requests by route and status.")
+	fmt.Fprintln(w, "# TYPE weather_http_requests_total counter")
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b requestKey) int {
+		return cmp.Or(cmp.Compare(a.path, b.path), cmp.Compare(a.status, b.status))
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "weather_http_requests_total{path=%q,status=\"%d\"} %d\n", k.path, k.status, m.requests[k])
+	}
+
+	fmt.Fprintln(w, "# HELP weather_upstream_failures_total Failed Open-Meteo fetches.")
+	fmt.Fprintln(w, "# TYPE weather_upstream_failures_total counter")
+	fmt.Fprintf(w, "weather_upstream_failures_total %d\n", m.upstreamFailures)
+
+	fmt.Fprintln(w, "# HELP weather_upstream_latency_seconds Open-Meteo fetch latency, including retries.")
+	fmt.Fprintln(w, "# TYPE weather_upstream_latency_seconds histogram")
+	var cumulative uint64
+	for i, bound := range upstreamLatencyBuckets {
+		if m.latencyCounts != nil {
+			cumulative += m.latencyCounts[i]
+		}
+		fmt.Fprintf(w, "weather_upstream_latency_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "weather_upstream_latency_seconds_bucket{le=\"+Inf\"} %d\n", m.latencyCount)
+	fmt.Fprintf(w, "weather_upstream_latency_seconds_sum %g\n", m.latencySum)
+	fmt.Fprintf(w, "weather_upstream_latency_seconds_count %d\n", m.latencyCount)
+}
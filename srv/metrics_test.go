@@ -0,0 +1,43 @@
+package srv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	handler := server.routes()
+
+	for _, path := range []string{"/api/weather", "/api/weather", "/nope"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	server.ForecastURL = failing.URL
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/weather?units=metric", nil))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		`weather_http_requests_total{path="GET /api/weather",status="200"} 2`,
+		`weather_http_requests_total{path="GET /api/weather",status="503"} 1`,
+		`weather_http_requests_total{path="unmatched",status="404"} 1`,
+		`weather_upstream_failures_total 1`,
+		`weather_upstream_latency_seconds_count 2`,
+		`weather_upstream_latency_seconds_bucket{le="+Inf"} 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+}
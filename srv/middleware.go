@@ -0,0 +1,245 @@
+package srv
+
+import (
+	"compress/gzip"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+)
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// logRequests emits one log line per request with its method, path,
+// client IP, final status and duration.
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		slog.InfoContext(r.Context(), "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"client", s.clientIP(r),
+			"status", rec.status,
+			"duration", time.Since(start),
+		)
+	})
+}
+
+// recoverPanics turns a panicking handler into a logged error and a 500
+// response, rather than a reset connection with nothing in the logs. The
+// response is only written if the handler hadn't started one.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			// ErrAbortHandler is how handlers deliberately abort a
+			// response; net/http handles it quietly.
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			slog.ErrorContext(r.Context(), "panic serving request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", v,
+				"stack", string(debug.Stack()),
+			)
+			if !rec.wroteHeader {
+				httpError(rec, r, "Internal server error", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// defaultContentSecurityPolicy allows the page's own assets plus the
+// inline styles and event handlers its templates use.
+const defaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; object-src 'none'; base-uri 'self'; form-action 'self'"
+
+// WithContentSecurityPolicy sets the Content-Security-Policy sent with
+// every response, for templates that load other assets. Leave out
+// frame-ancestors, which the server adds. Empty sends no policy.
+func WithContentSecurityPolicy(csp string) Option {
+	return func(s *Server) {
+		s.ContentSecurityPolicy = csp
+	}
+}
+
+// securityHeaders sets nosniff, a referrer policy, the content security
+// policy and framing protection on every response. Framing is only
+// allowed for the compact page, which exists to be embedded.
+func (s *Server) securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		frameAncestors := "'none'"
+		if isCompact(r) {
+			frameAncestors = "*"
+		} else {
+			h.Set("X-Frame-Options", "DENY")
+		}
+		if s.ContentSecurityPolicy != "" {
+			h.Set("Content-Security-Policy", s.ContentSecurityPolicy+"; frame-ancestors "+frameAncestors)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowCORS adds Access-Control-Allow-Origin to responses for requests
+// from an origin in s.AllowedOrigins, so browser apps served elsewhere can
+// call the API.
+func (s *Server) allowCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		origin := r.Header.Get("Origin")
+		if slices.Contains(s.AllowedOrigins, "*") {
+			if origin != "" {
+				h.Set("Access-Control-Allow-Origin", "*")
+				h.Set("Access-Control-Expose-Headers", "ETag")
+			}
+		} else {
+			// The response depends on Origin, so caches must key on it.
+			h.Add("Vary", "Origin")
+			if origin != "" && slices.Contains(s.AllowedOrigins, origin) {
+				h.Set("Access-Control-Allow-Origin", origin)
+				h.Set("Access-Control-Expose-Headers", "ETag")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HandlePreflight answers CORS preflight requests for the API. Origins
+// that aren't allowed get no Access-Control-Allow-Origin header from
+// allowCORS, so the browser rejects the actual request.
+func (s *Server) HandlePreflight(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-None-Match")
+	h.Set("Access-Control-Max-Age", "86400")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireAPIToken rejects requests without "Authorization: Bearer
+// <s.APIToken>" with 401. If no token is configured every request is let
+// through.
+func (s *Server) requireAPIToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.APIToken != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.APIToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				writeJSONError(w, r, http.StatusUnauthorized, "unauthorized", "A valid API token is required")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// gzipResponseWriter compresses the body written through it. The gzip
+// stream is only started once the status is known to allow a body.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code != http.StatusNoContent && code != http.StatusNotModified && code >= http.StatusOK {
+		h := w.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close flushes the gzip stream, if one was started.
+func (w *gzipResponseWriter) close() error {
+	if w.gz == nil {
+		return nil
+	}
+	return w.gz.Close()
+}
+
+// gzipResponses compresses responses for clients that send
+// Accept-Encoding: gzip.
+func gzipResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(gw, r)
+		if err := gw.close(); err != nil {
+			slog.WarnContext(r.Context(), "close gzip writer", "path", r.URL.Path, "error", err)
+		}
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header value allows gzip.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		return !ok || strings.Trim(q, "0.") != ""
+	}
+	return false
+}
@@ -0,0 +1,334 @@
+package srv
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLogs redirects the default slog logger to a JSON buffer for the
+// duration of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+func TestDebugLogsUpstreamURLButNotToken(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON, WithAPIToken("s3cret-token"))
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	req := httptest.NewRequest(http.MethodGet, "/api/weather?units=metric", nil)
+	req.Header.Set("Authorization", "Bearer s3cret-token")
+	w := httptest.NewRecorder()
+	server.routes().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	logs := buf.String()
+	if !strings.Contains(logs, "upstream request") || !strings.Contains(logs, server.ForecastURL+"?") {
+		t.Errorf("expected the Open-Meteo URL at debug level, got %s", logs)
+	}
+	if strings.Contains(logs, "s3cret-token") {
+		t.Errorf("API token leaked into logs: %s", logs)
+	}
+}
+
+func TestLogRequests(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	logs := captureLogs(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/static/missing.css", nil)
+	w := httptest.NewRecorder()
+	server.routes().ServeHTTP(w, req)
+
+	var entry struct {
+		Msg      string `json:"msg"`
+		Method   string `json:"method"`
+		Path     string `json:"path"`
+		Client   string `json:"client"`
+		Status   int    `json:"status"`
+		Duration int64  `json:"duration"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("decode log line %q: %v", logs.String(), err)
+	}
+	if entry.Msg != "request" || entry.Method != http.MethodGet || entry.Path != "/static/missing.css" {
+		t.Errorf("unexpected log entry: %+v", entry)
+	}
+	if entry.Status != http.StatusNotFound {
+		t.Errorf("expected logged status 404, got %d", entry.Status)
+	}
+	if entry.Client != "192.0.2.1" {
+		t.Errorf("expected logged client 192.0.2.1, got %q", entry.Client)
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	logs := captureLogs(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	srv := httptest.NewServer(withRequestID(recoverPanics(mux)))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/panic")
+	if err != nil {
+		t.Fatalf("GET /panic: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", resp.StatusCode)
+	}
+	id := resp.Header.Get("X-Request-ID")
+	if id == "" || !strings.Contains(string(body), "Internal server error (request ID "+id+")") {
+		t.Errorf("expected a generic message with request ID %q, got %q", id, body)
+	}
+	if l := logs.String(); !strings.Contains(l, `"panic":"boom"`) || !strings.Contains(l, "TestRecoverPanics") {
+		t.Errorf("expected the panic and its stack logged, got %s", l)
+	}
+
+	resp, err = http.Get(srv.URL + "/ok")
+	if err != nil {
+		t.Fatalf("GET /ok after a panic: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the server to keep serving, got status %d", resp.StatusCode)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	request := func(handler http.Handler, method, path, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("any origin by default", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON)
+		w := request(server.routes(), http.MethodGet, "/api/weather", "https://app.example")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+			t.Errorf("expected Access-Control-Allow-Origin *, got %q", got)
+		}
+	})
+
+	t.Run("allowlist", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON, WithAllowedOrigins("https://app.example"))
+		handler := server.routes()
+
+		w := request(handler, http.MethodGet, "/api/locations", "https://app.example")
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example" {
+			t.Errorf("expected allowed origin echoed, got %q", got)
+		}
+		if got := w.Header().Get("Vary"); got != "Origin" {
+			t.Errorf("expected Vary: Origin, got %q", got)
+		}
+
+		w = request(handler, http.MethodGet, "/api/locations", "https://evil.example")
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("expected no Access-Control-Allow-Origin for disallowed origin, got %q", got)
+		}
+	})
+
+	t.Run("preflight", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON)
+		w := request(server.routes(), http.MethodOptions, "/api/locations", "https://app.example")
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("expected status 204, got %d", w.Code)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(got, "POST") {
+			t.Errorf("expected POST in Access-Control-Allow-Methods, got %q", got)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+			t.Errorf("expected Access-Control-Allow-Origin *, got %q", got)
+		}
+	})
+
+	t.Run("not on html page", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON)
+		w := request(server.routes(), http.MethodGet, "/", "https://app.example")
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("expected no CORS headers on the HTML page, got %q", got)
+		}
+	})
+}
+
+func TestGzipResponses(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	handler := server.routes()
+	// Warm the cache so every response below reports cached: true.
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/weather", nil))
+
+	plain := httptest.NewRecorder()
+	handler.ServeHTTP(plain, httptest.NewRequest(http.MethodGet, "/api/weather", nil))
+	if got := plain.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("expected no Content-Encoding without Accept-Encoding, got %q", got)
+	}
+
+	for _, path := range []string{"/", "/api/weather"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", "br, gzip")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d", path, w.Code)
+		}
+		if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Fatalf("%s: expected Content-Encoding gzip, got %q", path, got)
+		}
+		if got := w.Header().Get("Vary"); !strings.Contains(got, "Accept-Encoding") {
+			t.Errorf("%s: expected Vary to include Accept-Encoding, got %q", path, got)
+		}
+		zr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("%s: open gzip body: %v", path, err)
+		}
+		body, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("%s: decompress body: %v", path, err)
+		}
+		if path == "/api/weather" && !bytes.Equal(body, plain.Body.Bytes()) {
+			t.Errorf("decompressed body differs from uncompressed response:\n%s\nvs\n%s", body, plain.Body.Bytes())
+		}
+		if path == "/" && !strings.Contains(string(body), "41") {
+			t.Errorf("expected decompressed page to contain the temperature, got %q", body)
+		}
+	}
+
+	t.Run("not modified", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/weather", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("If-None-Match", plain.Header().Get("ETag"))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusNotModified {
+			t.Fatalf("expected status 304, got %d", w.Code)
+		}
+		if w.Header().Get("Content-Encoding") != "" || w.Body.Len() != 0 {
+			t.Errorf("expected empty, unencoded 304, got encoding %q and %d bytes", w.Header().Get("Content-Encoding"), w.Body.Len())
+		}
+	})
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":                  false,
+		"gzip":              true,
+		"deflate, gzip":     true,
+		"GZIP;q=0.5":        true,
+		"gzip;q=0":          false,
+		"gzip; q=0.000, br": false,
+		"identity, deflate": false,
+	}
+	for header, expected := range tests {
+		if got := acceptsGzip(header); got != expected {
+			t.Errorf("acceptsGzip(%q) = %v, expected %v", header, got, expected)
+		}
+	}
+}
+
+func TestRequireAPIToken(t *testing.T) {
+	request := func(handler http.Handler, method, path, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("unset", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON)
+		if w := request(server.routes(), http.MethodGet, "/api/weather", ""); w.Code != http.StatusOK {
+			t.Errorf("expected open API without a token, got status %d", w.Code)
+		}
+	})
+
+	t.Run("set", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON, WithAPIToken("s3cret"))
+		handler := server.routes()
+
+		for _, auth := range []string{"", "Bearer wrong", "s3cret", "Basic czNjcmV0"} {
+			w := request(handler, http.MethodGet, "/api/weather", auth)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("Authorization %q: expected status 401, got %d", auth, w.Code)
+			}
+			if !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Bearer") {
+				t.Errorf("Authorization %q: expected WWW-Authenticate: Bearer, got %q", auth, w.Header().Get("WWW-Authenticate"))
+			}
+		}
+		if w := request(handler, http.MethodGet, "/api/weather", "Bearer s3cret"); w.Code != http.StatusOK {
+			t.Errorf("expected status 200 with the token, got %d", w.Code)
+		}
+		if w := request(handler, http.MethodGet, "/", ""); w.Code != http.StatusOK {
+			t.Errorf("expected the HTML page to stay public, got status %d", w.Code)
+		}
+		if w := request(handler, http.MethodOptions, "/api/weather", ""); w.Code != http.StatusNoContent {
+			t.Errorf("expected preflight without a token to succeed, got status %d", w.Code)
+		}
+	})
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	get := func(t *testing.T, server *Server, target string) http.Header {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w.Header()
+	}
+	server, _ := newStubServer(t, stubForecastJSON)
+
+	// The page, an error page and the API all get the same protection.
+	for _, target := range []string{"/", "/?units=kelvin", "/api/weather"} {
+		h := get(t, server, target)
+		if h.Get("X-Content-Type-Options") != "nosniff" || h.Get("Referrer-Policy") != "strict-origin-when-cross-origin" {
+			t.Errorf("%s: missing nosniff or referrer policy, got %v", target, h)
+		}
+		if h.Get("X-Frame-Options") != "DENY" {
+			t.Errorf("%s: expected X-Frame-Options DENY, got %q", target, h.Get("X-Frame-Options"))
+		}
+		if csp := h.Get("Content-Security-Policy"); csp != defaultContentSecurityPolicy+"; frame-ancestors 'none'" {
+			t.Errorf("%s: unexpected policy %q", target, csp)
+		}
+	}
+
+	h := get(t, server, "/?compact=1")
+	if h.Get("X-Frame-Options") != "" || !strings.HasSuffix(h.Get("Content-Security-Policy"), "; frame-ancestors *") {
+		t.Errorf("expected the compact page to allow framing, got %v", h)
+	}
+
+	server, _ = newStubServer(t, stubForecastJSON, WithContentSecurityPolicy("default-src https:"))
+	if csp := get(t, server, "/").Get("Content-Security-Policy"); csp != "default-src https:; frame-ancestors 'none'" {
+		t.Errorf("expected the configured policy, got %q", csp)
+	}
+	server, _ = newStubServer(t, stubForecastJSON, WithContentSecurityPolicy(""))
+	if h := get(t, server, "/"); h.Get("Content-Security-Policy") != "" || h.Get("X-Frame-Options") != "DENY" {
+		t.Errorf("expected no policy but framing still denied, got %v", h)
+	}
+}
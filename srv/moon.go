@@ -0,0 +1,45 @@
+package srv
+
+import (
+	"math"
+	"time"
+)
+
+// synodicMonth is the mean time between new moons, in days.
+const synodicMonth = 29.530588853
+
+// referenceNewMoon is a known new moon used as the epoch for moonAge.
+var referenceNewMoon = time.Date(2000, 1, 6, 18, 14, 0, 0, time.UTC)
+
+// moonPhases are the eight named phases, starting at new moon.
+var moonPhases = [8]struct {
+	name  string
+	emoji string
+}{
+	{"New Moon", "🌑"},
+	{"Waxing Crescent", "🌒"},
+	{"First Quarter", "🌓"},
+	{"Waxing Gibbous", "🌔"},
+	{"Full Moon", "🌕"},
+	{"Waning Gibbous", "🌖"},
+	{"Last Quarter", "🌗"},
+	{"Waning Crescent", "🌘"},
+}
+
+// moonAge returns the days since the last new moon at t, using the mean
+// synodic month. It drifts from the true phase by up to about a day, which
+// is plenty for naming the phase.
+func moonAge(t time.Time) float64 {
+	days := t.Sub(referenceNewMoon).Hours() / 24
+	age := math.Mod(days, synodicMonth)
+	if age < 0 {
+		age += synodicMonth
+	}
+	return age
+}
+
+// moonPhase returns the name and emoji of the moon phase at t.
+func moonPhase(t time.Time) (name string, emoji string) {
+	i := int(math.Floor(moonAge(t)/synodicMonth*8+0.5)) % 8
+	return moonPhases[i].name, moonPhases[i].emoji
+}
@@ -0,0 +1,50 @@
+package srv
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMoonPhase(t *testing.T) {
+	tests := []struct {
+		name     string
+		at       time.Time
+		age      float64
+		expected string
+	}{
+		{"new moon Jan 2024", time.Date(2024, 1, 11, 11, 57, 0, 0, time.UTC), 0, "New Moon"},
+		{"full moon Jan 2024", time.Date(2024, 1, 25, 17, 54, 0, 0, time.UTC), synodicMonth / 2, "Full Moon"},
+		{"full moon Jan 2025", time.Date(2025, 1, 13, 22, 27, 0, 0, time.UTC), synodicMonth / 2, "Full Moon"},
+		{"new moon Jan 2025", time.Date(2025, 1, 29, 12, 36, 0, 0, time.UTC), 0, "New Moon"},
+		{"before the epoch", time.Date(1999, 12, 22, 17, 31, 0, 0, time.UTC), synodicMonth / 2, "Full Moon"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Distance on the cycle, so an age just under a full month
+			// counts as close to a new moon.
+			diff := math.Abs(moonAge(test.at) - test.age)
+			diff = math.Min(diff, synodicMonth-diff)
+			if diff > 1 {
+				t.Errorf("moonAge = %.2f days, expected %.2f ± 1", moonAge(test.at), test.age)
+			}
+			if name, _ := moonPhase(test.at); name != test.expected {
+				t.Errorf("moonPhase = %q, expected %q", name, test.expected)
+			}
+		})
+	}
+}
+
+func TestHandleRootMoonPhase(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	w := httptest.NewRecorder()
+	server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	name, emoji := moonPhase(server.Now())
+	if body := w.Body.String(); !strings.Contains(body, name) || !strings.Contains(body, emoji) {
+		t.Errorf("expected moon phase %s %s on the page", emoji, name)
+	}
+}
@@ -0,0 +1,19 @@
+package srv
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPIDoc describes the /api/weather endpoint for client generators. It
+// is written by hand; TestOpenAPISchemas keeps it in step with the structs
+// the API serves.
+//
+//go:embed openapi.json
+var openAPIDoc []byte
+
+// HandleOpenAPI serves the OpenAPI description of the JSON API.
+func (s *Server) HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDoc)
+}
@@ -0,0 +1,167 @@
+package srv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type openAPISchema struct {
+	Type       any                      `json:"type"`
+	Ref        string                   `json:"$ref"`
+	Required   []string                 `json:"required"`
+	Properties map[string]openAPISchema `json:"properties"`
+}
+
+func loadOpenAPISchemas(t *testing.T) map[string]openAPISchema {
+	t.Helper()
+	var doc struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]openAPISchema `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(openAPIDoc, &doc); err != nil {
+		t.Fatalf("parse openapi.json: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("openapi = %q, want 3.x", doc.OpenAPI)
+	}
+	for _, path := range []string{"/api/weather", "/api/v1/weather", "/api/v1/weather/current", "/api/v1/weather/hourly"} {
+		if _, ok := doc.Paths[path]["get"]; !ok {
+			t.Errorf("openapi.json does not describe GET %s", path)
+		}
+	}
+	return doc.Components.Schemas
+}
+
+// jsonFields returns the JSON names of typ's fields and the schema type
+// each should have. Pointers to basic types are nullable, like
+// "number|null".
+func jsonFields(typ reflect.Type) map[string]string {
+	fields := make(map[string]string)
+	for i := range typ.NumField() {
+		f := typ.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" {
+			name = f.Name
+		}
+		if f.Type.Kind() == reflect.Pointer && f.Type.Elem().Kind() == reflect.Float64 {
+			fields[name] = "number|null"
+			continue
+		}
+		switch f.Type.Kind() {
+		case reflect.Float64:
+			fields[name] = "number"
+		case reflect.Int:
+			fields[name] = "integer"
+		case reflect.Bool:
+			fields[name] = "boolean"
+		case reflect.String:
+			fields[name] = "string"
+		case reflect.Slice:
+			fields[name] = "array"
+		default:
+			fields[name] = "$ref"
+		}
+	}
+	return fields
+}
+
+func TestOpenAPISchemas(t *testing.T) {
+	schemas := loadOpenAPISchemas(t)
+	for name, typ := range map[string]reflect.Type{
+		"WeatherData":    reflect.TypeFor[WeatherData](),
+		"UnitLabels":     reflect.TypeFor[UnitLabels](),
+		"HourlyForecast": reflect.TypeFor[HourlyForecast](),
+		"DailyForecast":  reflect.TypeFor[DailyForecast](),
+		"AirQuality":     reflect.TypeFor[AirQuality](),
+		"Alert":          reflect.TypeFor[Alert](),
+		"DataSource":     reflect.TypeFor[DataSource](),
+	} {
+		schema, ok := schemas[name]
+		if !ok {
+			t.Errorf("openapi.json has no %s schema", name)
+			continue
+		}
+		want := jsonFields(typ)
+		for field, kind := range want {
+			prop, ok := schema.Properties[field]
+			if !ok {
+				t.Errorf("%s schema is missing %s", name, field)
+				continue
+			}
+			got := prop.Type
+			if types, ok := got.([]any); ok {
+				got = fmt.Sprintf("%v|%v", types...)
+			}
+			if prop.Ref != "" {
+				got = "$ref"
+			}
+			if got != kind {
+				t.Errorf("%s.%s has type %v, want %s", name, field, got, kind)
+			}
+		}
+		for field := range schema.Properties {
+			if _, ok := want[field]; !ok {
+				t.Errorf("%s schema has %s, which %s does not", name, field, typ)
+			}
+		}
+	}
+}
+
+func TestOpenAPIResponseFields(t *testing.T) {
+	schemas := loadOpenAPISchemas(t)
+	server, _ := newStubServer(t, stubForecastJSON)
+
+	rec := httptest.NewRecorder()
+	server.HandleAPI(rec, httptest.NewRequest(http.MethodGet, "/api/weather", nil))
+	var resp map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	var got []string
+	for field := range resp {
+		got = append(got, field)
+	}
+	slices.Sort(got)
+	want := slices.Sorted(slices.Values(schemas["WeatherResponse"].Required))
+	if !slices.Equal(got, want) {
+		t.Errorf("response fields = %v, schema requires %v", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	writeJSONError(rec, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(context.WithValue(t.Context(), requestIDKey{}, "abc")), http.StatusBadRequest, "invalid_request", "bad")
+	var errBody map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &errBody); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	for field := range errBody {
+		if _, ok := schemas["Error"].Properties[field]; !ok {
+			t.Errorf("error body has %s, which the Error schema does not", field)
+		}
+	}
+}
+
+func TestHandleOpenAPI(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON, WithAPIToken("secret"))
+
+	rec := httptest.NewRecorder()
+	server.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 without a token", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	if !json.Valid(rec.Body.Bytes()) {
+		t.Error("body is not valid JSON")
+	}
+}
@@ -0,0 +1,88 @@
+package srv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// WeatherProvider is a source of forecasts. Server tries its Providers in
+// order and uses the first that succeeds.
+type WeatherProvider interface {
+	// Fetch returns the current conditions and forecast for a location in
+	// the given units.
+	Fetch(ctx context.Context, lat, lon float64, units UnitSystem) (*Forecast, error)
+}
+
+// openMeteoProvider fetches from s.ForecastURL. It is the default, and
+// first, provider.
+type openMeteoProvider struct {
+	s *Server
+}
+
+func (p openMeteoProvider) Fetch(ctx context.Context, lat, lon float64, units UnitSystem) (*Forecast, error) {
+	return p.s.fetchOpenMeteo(ctx, newForecastQuery(lat, lon, units))
+}
+
+func (p openMeteoProvider) fetchQuery(ctx context.Context, q forecastQuery) (*Forecast, error) {
+	return p.s.fetchOpenMeteo(ctx, q)
+}
+
+// queryFetcher is implemented by providers that can honor every field of a
+// forecastQuery, such as a longer hourly forecast. Other providers are
+// asked through Fetch and return however many hours they have.
+type queryFetcher interface {
+	fetchQuery(ctx context.Context, q forecastQuery) (*Forecast, error)
+}
+
+// WithProviders replaces the default Open-Meteo provider with providers,
+// tried in order.
+func WithProviders(providers ...WeatherProvider) Option {
+	return func(s *Server) {
+		s.Providers = providers
+	}
+}
+
+// WithFallbackProviders adds providers to try, in order, when Open-Meteo
+// fails.
+func WithFallbackProviders(providers ...WeatherProvider) Option {
+	return func(s *Server) {
+		s.Providers = append(s.Providers, providers...)
+	}
+}
+
+// fetchFromProviders asks each provider in turn for q until one succeeds.
+// If all fail, the returned error wraps every provider's error.
+func (s *Server) fetchFromProviders(ctx context.Context, q forecastQuery) (*Forecast, error) {
+	if len(s.Providers) == 0 {
+		return nil, errors.New("no weather providers configured")
+	}
+	var errs []error
+	for i, p := range s.Providers {
+		start := time.Now()
+		var forecast *Forecast
+		var err error
+		if qf, ok := p.(queryFetcher); ok {
+			forecast, err = qf.fetchQuery(ctx, q)
+		} else {
+			forecast, err = p.Fetch(ctx, q.Lat, q.Lon, q.Units)
+		}
+		if err == nil && (forecast == nil || forecast.Current == nil) {
+			err = errors.New("no current conditions in forecast")
+		}
+		s.metrics.observeUpstream(time.Since(start), err)
+		if err == nil {
+			return forecast, nil
+		}
+		errs = append(errs, fmt.Errorf("provider %d (%T): %w", i, p, err))
+		if ctx.Err() != nil {
+			break
+		}
+		if i < len(s.Providers)-1 {
+			slog.WarnContext(ctx, "weather provider failed, trying next", "provider", fmt.Sprintf("%T", p), "error", err)
+		}
+	}
+	return nil, errors.Join(errs...)
+}
@@ -0,0 +1,97 @@
+package srv
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeProvider returns forecast or err and counts its calls.
+type fakeProvider struct {
+	forecast *Forecast
+	err      error
+	calls    int
+}
+
+func (p *fakeProvider) Fetch(ctx context.Context, lat, lon float64, units UnitSystem) (*Forecast, error) {
+	p.calls++
+	return p.forecast, p.err
+}
+
+func TestProviders(t *testing.T) {
+	fake := func(temp float64) *fakeProvider {
+		return &fakeProvider{forecast: &Forecast{Current: &WeatherData{Temperature: temp}}}
+	}
+
+	t.Run("fake replaces Open-Meteo", func(t *testing.T) {
+		provider := fake(12.5)
+		server, hits := newStubServer(t, stubForecastJSON, WithProviders(provider))
+		forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+		if err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		if forecast.Current.Temperature != 12.5 || provider.calls != 1 {
+			t.Errorf("expected the fake's forecast from one call, got %v after %d calls", forecast.Current.Temperature, provider.calls)
+		}
+		if hits.Load() != 0 {
+			t.Errorf("expected no Open-Meteo requests, got %d", hits.Load())
+		}
+	})
+
+	t.Run("falls back when Open-Meteo fails", func(t *testing.T) {
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		t.Cleanup(failing.Close)
+		fallback := fake(50)
+		server, _ := newStubServer(t, stubForecastJSON, WithForecastURL(failing.URL), WithFallbackProviders(fallback))
+
+		forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+		if err != nil {
+			t.Fatalf("expected the fallback to succeed, got %v", err)
+		}
+		if forecast.Current.Temperature != 50 {
+			t.Errorf("expected the fallback's forecast, got %v", forecast.Current.Temperature)
+		}
+	})
+
+	t.Run("stops at first success", func(t *testing.T) {
+		first, second := fake(1), fake(2)
+		server, _ := newStubServer(t, stubForecastJSON, WithProviders(first, second))
+		if _, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial)); err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		if second.calls != 0 {
+			t.Errorf("expected the second provider to be skipped, got %d calls", second.calls)
+		}
+	})
+
+	t.Run("empty forecast counts as failure", func(t *testing.T) {
+		empty := &fakeProvider{forecast: &Forecast{}}
+		server, _ := newStubServer(t, stubForecastJSON, WithProviders(empty, fake(3)))
+		forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+		if err != nil || forecast.Current.Temperature != 3 {
+			t.Errorf("expected to fall through an empty forecast, got %+v, %v", forecast, err)
+		}
+	})
+
+	t.Run("all fail", func(t *testing.T) {
+		rateLimited := &fakeProvider{err: &UpstreamError{StatusCode: http.StatusTooManyRequests}}
+		down := &fakeProvider{err: errors.New("connection refused")}
+		server, _ := newStubServer(t, stubForecastJSON, WithProviders(rateLimited, down))
+
+		_, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+		if err == nil {
+			t.Fatal("expected an error when every provider fails")
+		}
+		var upstreamErr *UpstreamError
+		if !errors.As(err, &upstreamErr) || upstreamErr.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("expected the joined error to keep the UpstreamError, got %v", err)
+		}
+		if rateLimited.calls != 1 || down.calls != 1 {
+			t.Errorf("expected each provider to be tried once, got %d and %d", rateLimited.calls, down.calls)
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package srv
+
+import (
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default per-client limits for the weather endpoints
+const (
+	defaultRateLimit = 1.0 // requests per second
+	defaultRateBurst = 30
+)
+
+// rateLimiter is a set of token buckets keyed by client IP. It is safe for
+// concurrent use.
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// allow takes a token from key's bucket, which refills at rate tokens per
+// second up to burst. If the bucket is empty it returns false and how long
+// until the next token.
+func (l *rateLimiter) allow(key string, now time.Time, rate float64, burst int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	l.sweep(now, rate, burst)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(float64(burst), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops buckets that have been idle long enough to refill, since a
+// full bucket behaves the same as a missing one. It runs at most once per
+// refill period so memory stays bounded by the number of recent clients.
+func (l *rateLimiter) sweep(now time.Time, rate float64, burst int) {
+	refill := time.Duration(float64(burst) / rate * float64(time.Second))
+	if now.Sub(l.lastSweep) < refill {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// clientIP returns the IP address of the client that sent r, for rate
+// limiting and logging. With s.TrustForwardedFor it is taken from
+// X-Forwarded-For: each of the s.TrustedProxies reverse proxies in front of
+// us appends the address it received the request from, so the entry that
+// many from the end is the first hop we can't vouch for, and everything
+// before it is client-supplied and can be forged. It falls back to the
+// connection's address when the header is missing or that entry isn't an
+// IP address.
+func (s *Server) clientIP(r *http.Request) string {
+	if s.TrustForwardedFor {
+		var hops []string
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+		if len(hops) > 0 {
+			hop := hops[max(0, len(hops)-s.TrustedProxies)]
+			if ip, ok := parseHostIP(hop); ok {
+				return ip
+			}
+		}
+	}
+	if ip, ok := parseHostIP(r.RemoteAddr); ok {
+		return ip
+	}
+	return r.RemoteAddr
+}
+
+// parseHostIP parses an IPv4 or IPv6 address with or without a port, like
+// "203.0.113.1", "203.0.113.1:4444", "2001:db8::1" or "[2001:db8::1]:443",
+// and returns it in canonical form. IPv4-mapped IPv6 addresses are
+// returned as IPv4 so a client has one key however it connected.
+func parseHostIP(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap().String(), true
+	}
+	if ip, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")); err == nil {
+		return ip.Unmap().String(), true
+	}
+	return "", false
+}
+
+// rateLimit rejects requests with 429 once a client IP exceeds
+// s.RateLimit requests per second, allowing bursts of s.RateBurst. A zero
+// RateLimit disables limiting.
+func (s *Server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.RateLimit > 0 {
+			ok, wait := s.limiter.allow(s.clientIP(r), s.Now(), s.RateLimit, s.RateBurst)
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				writeJSONError(w, r, http.StatusTooManyRequests, "rate_limited", "Too many requests; slow down")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
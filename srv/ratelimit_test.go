@@ -0,0 +1,156 @@
+package srv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	request := func(handler http.Handler, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/weather", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("burst then refill", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON, WithRateLimit(0.5, 2))
+		handler := server.routes()
+		for i := 0; i < 2; i++ {
+			if w := request(handler, "192.0.2.1:1234", ""); w.Code != http.StatusOK {
+				t.Fatalf("request %d: expected status 200, got %d", i, w.Code)
+			}
+		}
+		w := request(handler, "192.0.2.1:1234", "")
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("expected status 429 once the burst is used, got %d", w.Code)
+		}
+		if got := w.Header().Get("Retry-After"); got != "2" {
+			t.Errorf("expected Retry-After 2, got %q", got)
+		}
+		if w := request(handler, "192.0.2.2:1234", ""); w.Code != http.StatusOK {
+			t.Errorf("expected another client to be unaffected, got status %d", w.Code)
+		}
+
+		advanceClock(server, 2*time.Second)
+		if w := request(handler, "192.0.2.1:5678", ""); w.Code != http.StatusOK {
+			t.Errorf("expected a token after refilling, got status %d", w.Code)
+		}
+	})
+
+	t.Run("page", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON, WithRateLimit(1, 1))
+		handler := server.routes()
+		get := func(target string) int {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+			return w.Code
+		}
+		if code := get("/?q=Paris"); code == http.StatusTooManyRequests {
+			t.Fatal("expected the first page request through")
+		}
+		if code := get("/?refresh=1"); code != http.StatusTooManyRequests {
+			t.Errorf("expected the page to share the API's limit, got status %d", code)
+		}
+		if code := get("/api/weather"); code != http.StatusTooManyRequests {
+			t.Errorf("expected the API to count page requests, got status %d", code)
+		}
+	})
+
+	t.Run("forwarded for", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON, WithRateLimit(1, 1))
+		handler := server.routes()
+		request(handler, "10.0.0.1:1234", "198.51.100.7")
+		if w := request(handler, "10.0.0.1:1234", "198.51.100.8"); w.Code != http.StatusTooManyRequests {
+			t.Errorf("expected X-Forwarded-For to be ignored by default, got status %d", w.Code)
+		}
+
+		server.TrustForwardedFor = true
+		if w := request(handler, "10.0.0.1:1234", "198.51.100.8"); w.Code != http.StatusOK {
+			t.Errorf("expected trusted X-Forwarded-For to key the bucket, got status %d", w.Code)
+		}
+		if w := request(handler, "10.0.0.1:1234", "203.0.113.1, 198.51.100.8"); w.Code != http.StatusTooManyRequests {
+			t.Errorf("expected the proxy-added entry to key the bucket, got status %d", w.Code)
+		}
+	})
+
+	t.Run("invalid settings", func(t *testing.T) {
+		for _, opt := range []Option{WithRateLimit(-1, 5), WithRateLimit(1, 0)} {
+			if _, err := New(filepath.Join(t.TempDir(), "db.sqlite3"), "test-hostname", opt); err == nil {
+				t.Error("expected an error for an invalid rate limit")
+			}
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON, WithRateLimit(0, 0))
+		handler := server.routes()
+		for i := 0; i < 50; i++ {
+			if w := request(handler, "192.0.2.1:1234", ""); w.Code != http.StatusOK {
+				t.Fatalf("request %d: expected status 200, got %d", i, w.Code)
+			}
+		}
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		proxies      int // 0 ignores X-Forwarded-For
+		want         string
+	}{
+		{"ipv4", "203.0.113.1:4444", nil, 0, "203.0.113.1"},
+		{"ipv6", "[::1]:1234", nil, 0, "::1"},
+		{"ipv4-mapped ipv6", "[::ffff:203.0.113.1]:4444", nil, 0, "203.0.113.1"},
+		{"no port", "203.0.113.1", nil, 0, "203.0.113.1"},
+		{"forwarded ignored", "10.0.0.1:1234", []string{"198.51.100.7"}, 0, "10.0.0.1"},
+		{"one proxy", "10.0.0.1:1234", []string{"203.0.113.9, 198.51.100.7"}, 1, "198.51.100.7"},
+		{"two proxies", "10.0.0.1:1234", []string{"203.0.113.9, 198.51.100.7, 10.0.0.2"}, 2, "198.51.100.7"},
+		{"split headers", "10.0.0.1:1234", []string{"203.0.113.9", "198.51.100.7, 10.0.0.2"}, 2, "198.51.100.7"},
+		{"chain shorter than proxies", "10.0.0.1:1234", []string{"198.51.100.7"}, 3, "198.51.100.7"},
+		{"forwarded ipv6", "10.0.0.1:1234", []string{"2001:DB8::1"}, 1, "2001:db8::1"},
+		{"forwarded ipv6 with port", "10.0.0.1:1234", []string{"[2001:db8::1]:443"}, 1, "2001:db8::1"},
+		{"forwarded ipv4 with port", "10.0.0.1:1234", []string{"198.51.100.7:4444"}, 1, "198.51.100.7"},
+		{"forwarded garbage", "10.0.0.1:1234", []string{"unknown"}, 1, "10.0.0.1"},
+		{"empty forwarded", "[::1]:1234", []string{""}, 1, "::1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &Server{Config: Config{TrustForwardedFor: test.proxies > 0, TrustedProxies: test.proxies}}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = test.remoteAddr
+			for _, v := range test.forwardedFor {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if got := server.clientIP(req); got != test.want {
+				t.Errorf("clientIP = %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	if _, err := New(filepath.Join(t.TempDir(), "db.sqlite3"), "test-hostname", WithTrustedProxies(0)); err == nil {
+		t.Error("expected an error for zero trusted proxies")
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	var l rateLimiter
+	start := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		l.allow(ip, start, 1, 5)
+	}
+	// The buckets refill after 5s; touching the limiter then drops them.
+	l.allow("192.0.2.9", start.Add(5*time.Second), 1, 5)
+	if len(l.buckets) != 1 {
+		t.Errorf("expected idle buckets to be swept, %d remain", len(l.buckets))
+	}
+}
@@ -0,0 +1,115 @@
+package srv
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDGenerated(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+
+	w := httptest.NewRecorder()
+	server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	id := w.Header().Get("X-Request-ID")
+	if len(id) != 16 {
+		t.Fatalf("X-Request-ID = %q, want 16 hex characters", id)
+	}
+
+	w = httptest.NewRecorder()
+	server.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if other := w.Header().Get("X-Request-ID"); other == id {
+		t.Errorf("two requests got the same ID %q", id)
+	}
+}
+
+func TestRequestIDFromHeader(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+
+	tests := []struct {
+		name, header string
+		reused       bool
+	}{
+		{"well formed", "abc-123", true},
+		{"contains space", "abc 123", false},
+		{"control character", "abc\x01", false},
+		{"too long", strings.Repeat("a", maxRequestIDLen+1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+			req.Header.Set("X-Request-ID", tt.header)
+			w := httptest.NewRecorder()
+			server.routes().ServeHTTP(w, req)
+			got := w.Header().Get("X-Request-ID")
+			if (got == tt.header) != tt.reused {
+				t.Errorf("X-Request-ID = %q, want reused=%v", got, tt.reused)
+			}
+			if got == "" {
+				t.Error("no X-Request-ID in response")
+			}
+		})
+	}
+}
+
+func TestRequestIDInLogs(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	logs := captureLogs(t)
+	slog.SetDefault(slog.New(NewLogHandler(slog.Default().Handler())))
+
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set("X-Request-ID", "trace-me")
+	server.routes().ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry struct {
+		Msg       string `json:"msg"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("log output %q: %v", logs.String(), err)
+	}
+	if entry.Msg != "request" || entry.RequestID != "trace-me" {
+		t.Errorf("log entry = %+v, want request with request_id trace-me", entry)
+	}
+}
+
+func TestLogHandlerWithoutRequest(t *testing.T) {
+	logs := captureLogs(t)
+	logger := slog.New(NewLogHandler(slog.Default().Handler())).With("component", "test")
+
+	logger.InfoContext(context.Background(), "hello")
+	if strings.Contains(logs.String(), "request_id") {
+		t.Errorf("log outside a request has request_id: %s", logs)
+	}
+}
+
+func TestRequestIDInErrors(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/weather?location=Atlantis", nil)
+	req.Header.Set("X-Request-ID", "api-err")
+	w := httptest.NewRecorder()
+	server.routes().ServeHTTP(w, req)
+	var body struct {
+		Code      string `json:"code"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != "unknown_location" || body.RequestID != "api-err" {
+		t.Errorf("error body = %+v, want unknown_location with request_id api-err", body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/?location=Atlantis", nil)
+	req.Header.Set("X-Request-ID", "page-err")
+	w = httptest.NewRecorder()
+	server.routes().ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "request ID page-err") {
+		t.Errorf("HTML error = %d %q, want 404 mentioning the request ID", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,61 @@
+package srv
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	defaultMaxRetries     = 3
+	defaultRetryBaseDelay = 200 * time.Millisecond
+	// maxRetryDelay caps the exponential backoff so a large MaxRetries
+	// doesn't stall a fetch for minutes between attempts.
+	maxRetryDelay = 10 * time.Second
+)
+
+// RetryPolicy returns how long to wait before retry number attempt of a
+// failed upstream request, counting from 0.
+type RetryPolicy func(attempt int) time.Duration
+
+// ExponentialBackoff returns the default RetryPolicy: base doubled on
+// each attempt, up to 10s, with the upper half of each delay randomized
+// so that many servers failing together don't retry in lockstep.
+func ExponentialBackoff(base time.Duration) RetryPolicy {
+	return func(attempt int) time.Duration {
+		d := base
+		for range attempt {
+			if d >= maxRetryDelay {
+				break
+			}
+			d *= 2
+		}
+		half := min(d, maxRetryDelay) / 2
+		return half + rand.N(half+1)
+	}
+}
+
+// WithRetries sets how many times a failed upstream request is retried,
+// and the base delay of the default exponential backoff. Zero retries
+// disables retrying.
+func WithRetries(max int, base time.Duration) Option {
+	return func(s *Server) {
+		s.MaxRetries = max
+		s.RetryBaseDelay = base
+	}
+}
+
+// WithRetryPolicy replaces the default exponential backoff with p.
+// MaxRetries still bounds the number of retries.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(s *Server) {
+		s.RetryPolicy = p
+	}
+}
+
+// retryDelay returns the delay before retry number attempt.
+func (s *Server) retryDelay(attempt int) time.Duration {
+	if s.RetryPolicy != nil {
+		return s.RetryPolicy(attempt)
+	}
+	return ExponentialBackoff(s.RetryBaseDelay)(attempt)
+}
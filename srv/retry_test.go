@@ -0,0 +1,82 @@
+package srv
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	policy := ExponentialBackoff(100 * time.Millisecond)
+	for attempt, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond} {
+		for range 50 {
+			if d := policy(attempt); d < want/2 || d > want {
+				t.Fatalf("attempt %d: delay %v outside [%v, %v]", attempt, d, want/2, want)
+			}
+		}
+	}
+	for _, attempt := range []int{10, 64, 1000} {
+		if d := policy(attempt); d < maxRetryDelay/2 || d > maxRetryDelay {
+			t.Errorf("attempt %d: delay %v not capped at %v", attempt, d, maxRetryDelay)
+		}
+	}
+	if d := ExponentialBackoff(time.Hour)(3); d > maxRetryDelay {
+		t.Errorf("a long base delay should still be capped, got %v", d)
+	}
+
+	seen := make(map[time.Duration]bool)
+	for range 20 {
+		seen[policy(2)] = true
+	}
+	if len(seen) < 2 {
+		t.Error("expected jittered delays to vary")
+	}
+}
+
+func TestRetryAttempts(t *testing.T) {
+	failing := func(t *testing.T) (string, *atomic.Int64) {
+		var hits atomic.Int64
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		t.Cleanup(upstream.Close)
+		return upstream.URL, &hits
+	}
+
+	for _, retries := range []int{0, 1, 5} {
+		url, hits := failing(t)
+		server, _ := newStubServer(t, stubForecastJSON, WithForecastURL(url), WithRetries(retries, time.Microsecond))
+		if _, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial)); err == nil {
+			t.Fatalf("%d retries: expected an error from a failing upstream", retries)
+		}
+		if n := hits.Load(); n != int64(retries+1) {
+			t.Errorf("%d retries: expected %d upstream requests, got %d", retries, retries+1, n)
+		}
+	}
+
+	t.Run("custom policy", func(t *testing.T) {
+		url, hits := failing(t)
+		var attempts []int
+		policy := func(attempt int) time.Duration {
+			attempts = append(attempts, attempt)
+			return 0
+		}
+		server, _ := newStubServer(t, stubForecastJSON, WithForecastURL(url), WithRetries(2, time.Hour), WithRetryPolicy(policy))
+		start := time.Now()
+		server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+		if n := hits.Load(); n != 3 {
+			t.Errorf("expected 3 upstream requests, got %d", n)
+		}
+		if !slices.Equal(attempts, []int{0, 1}) {
+			t.Errorf("expected the policy to be asked for retries 0 and 1, got %v", attempts)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("expected the policy's delays to replace the default backoff, took %v", elapsed)
+		}
+	})
+}
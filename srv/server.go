@@ -1,306 +1,698 @@
 package srv
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"log/slog"
+	"net"
 	"net/http"
-	"path/filepath"
-	"runtime"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
-	"srv.exe.dev/db"
+	"golang.org/x/sync/singleflight"
 )
 
+// Server serves the weather page and API. Its settings are in the
+// embedded Config; the other exported fields are dependencies that tests
+// and callers may swap out.
 type Server struct {
-	DB           *sql.DB
-	Hostname     string
-	TemplatesDir string
-	StaticDir    string
+	Config
+	Store      Store
+	HTTPClient *http.Client
+	Providers  []WeatherProvider
+	Now        func() time.Time
+	// RetryPolicy, if set, replaces the exponential backoff between
+	// retries of failed upstream requests.
+	RetryPolicy RetryPolicy
+
+	timezone        *time.Location
+	templates       *template.Template
+	staticFS        fs.FS
+	cache           weatherCache
+	refreshing      atomic.Bool
+	metrics         metrics
+	limiter         rateLimiter
+	forcedRefreshes rateLimiter
+	updates         updateBroadcaster
+	fetchHealth     fetchHealth
+	inflight        singleflight.Group
+	geocodes        geocodeCache
 }
 
-// Brooklyn, NY coordinates
+// Brooklyn, NY is the default location
 const (
-	brooklynLat = 40.6782
-	brooklynLon = -73.9442
+	brooklynName = "Brooklyn, NY"
+	brooklynLat  = 40.6782
+	brooklynLon  = -73.9442
 )
 
-// Weather data from Open-Meteo API
-type WeatherData struct {
-	Temperature     float64
-	FeelsLike       float64
-	Humidity        int
-	WindSpeed       float64
-	WindDirection   int
-	WeatherCode     int
-	IsDay           bool
-	Precipitation   float64
-	CloudCover      int
-	LastUpdated     string
-	Condition       string
-	ConditionEmoji  string
-}
-
-// HourlyForecast represents one hour of forecast data
-type HourlyForecast struct {
-	Time           string
-	Hour           string
-	Temperature    float64
-	WeatherCode    int
-	ConditionEmoji string
-	PrecipProb     int
-	IsDay          bool
+const (
+	openMeteoForecastURL = "https://api.open-meteo.com/v1/forecast"
+	defaultTimezone      = "America/New_York"
+	defaultForecastHours = 24
+	maxForecastHours     = 168
+	defaultCacheTTL      = 5 * time.Minute
+	defaultStaleOK       = 15 * time.Minute
+	defaultStaleMax      = 3 * time.Hour
+	shutdownTimeout      = 10 * time.Second
+	defaultFetchTimeout  = 10 * time.Second
+)
+
+// Option configures optional Server settings in New and NewWithConfig.
+type Option func(*Server)
+
+// WithLocation sets the place the server reports weather for.
+func WithLocation(name string, lat, lon float64) Option {
+	return func(s *Server) {
+		s.LocationName = name
+		s.Lat = lat
+		s.Lon = lon
+	}
+}
+
+// WithTimezone sets the IANA timezone, like "Europe/Paris", that forecast
+// times are reported and displayed in. It defaults to America/New_York.
+func WithTimezone(name string) Option {
+	return func(s *Server) {
+		s.Timezone = name
+	}
+}
+
+// WithCacheTTL sets how long a fetched forecast is reused before
+// Open-Meteo is queried again.
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(s *Server) {
+		s.CacheTTL = ttl
+	}
 }
 
 type pageData struct {
-	Hostname string
-	Now      string
-	Weather  *WeatherData
-	Hourly   []HourlyForecast
-	Error    string
-}
-
-// Open-Meteo API response structure
-type openMeteoResponse struct {
-	Current struct {
-		Time              string  `json:"time"`
-		Temperature2m     float64 `json:"temperature_2m"`
-		ApparentTemp      float64 `json:"apparent_temperature"`
-		RelativeHumidity  int     `json:"relative_humidity_2m"`
-		WindSpeed10m      float64 `json:"wind_speed_10m"`
-		WindDirection10m  int     `json:"wind_direction_10m"`
-		WeatherCode       int     `json:"weather_code"`
-		IsDay             int     `json:"is_day"`
-		Precipitation     float64 `json:"precipitation"`
-		CloudCover        int     `json:"cloud_cover"`
-	} `json:"current"`
-	Hourly struct {
-		Time            []string  `json:"time"`
-		Temperature2m   []float64 `json:"temperature_2m"`
-		WeatherCode     []int     `json:"weather_code"`
-		PrecipProb      []int     `json:"precipitation_probability"`
-		IsDay           []int     `json:"is_day"`
-	} `json:"hourly"`
-}
-
-func New(dbPath, hostname string) (*Server, error) {
-	_, thisFile, _, _ := runtime.Caller(0)
-	baseDir := filepath.Dir(thisFile)
-	srv := &Server{
-		Hostname:     hostname,
-		TemplatesDir: filepath.Join(baseDir, "templates"),
-		StaticDir:    filepath.Join(baseDir, "static"),
+	Hostname      string
+	Location      string
+	LocationParam string
+	PlaceQuery    string
+	Units         UnitSystem
+	Theme         string
+	// Compact selects the minimal page for embedding in an iframe.
+	Compact       bool
+	Now           string
+	Weather       *WeatherData
+	Hourly        []HourlyForecast
+	Daily         []DailyForecast
+	Error         string
+	StaleAsOf     string
+	PrecipTotal   float64
+	AirQuality    *AirQuality
+	MoonPhase     string
+	MoonEmoji     string
+	Lang          string
+	RequestID     string
+	TempTrend     string
+	Alert         *Alert
+	HasTodayRange bool
+	// HourlyUnavailable is set when a forecast has no upcoming hours.
+	HourlyUnavailable bool
+	// RainChanceToday is the highest hourly chance of precipitation left
+	// today, set when HasRainChance.
+	RainChanceToday int
+	HasRainChance   bool
+	TodayHigh       float64
+	TodayLow        float64
+	Source          DataSource
+}
+
+// WithRefreshInterval sets how often Serve refreshes forecasts in the
+// background.
+func WithRefreshInterval(interval time.Duration) Option {
+	return func(s *Server) {
+		s.RefreshInterval = interval
 	}
-	if err := srv.setUpDatabase(dbPath); err != nil {
-		return nil, err
+}
+
+// WithForecastURL sets the Open-Meteo compatible forecast endpoint.
+func WithForecastURL(u string) Option {
+	return func(s *Server) {
+		s.ForecastURL = u
 	}
-	return srv, nil
 }
 
-func (s *Server) fetchWeather() (*WeatherData, []HourlyForecast, error) {
-	url := fmt.Sprintf(
-		"https://api.open-meteo.com/v1/forecast?latitude=%.4f&longitude=%.4f&current=temperature_2m,relative_humidity_2m,apparent_temperature,precipitation,weather_code,cloud_cover,wind_speed_10m,wind_direction_10m,is_day&hourly=temperature_2m,weather_code,precipitation_probability,is_day&temperature_unit=fahrenheit&wind_speed_unit=mph&precipitation_unit=inch&timezone=America%%2FNew_York&forecast_hours=24",
-		brooklynLat, brooklynLon,
-	)
+// WithForecastHours sets how many hours of hourly forecast are requested.
+func WithForecastHours(hours int) Option {
+	return func(s *Server) {
+		s.ForecastHours = hours
+	}
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(url)
-	if err != nil {
-		return nil, nil, fmt.Errorf("fetch weather: %w", err)
+// WithHTTPClient sets the client used for upstream requests, for example to
+// point tests at a stub server.
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *Server) {
+		s.HTTPClient = client
+	}
+}
+
+// WithAllowedOrigins sets the origins allowed to call the JSON API from a
+// browser. "*" allows any origin.
+func WithAllowedOrigins(origins ...string) Option {
+	return func(s *Server) {
+		s.AllowedOrigins = origins
+	}
+}
+
+// WithStaleLimits sets how old a forecast the JSON API serves. Up to ok
+// old it is served as usual, up to max old it is served marked stale, and
+// past that the API answers 503 rather than serve dangerously outdated
+// weather. Forecasts age when upstream fetches fail and the API falls
+// back to the last good one.
+func WithStaleLimits(ok, max time.Duration) Option {
+	return func(s *Server) {
+		s.StaleOK = ok
+		s.StaleMax = max
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, nil, fmt.Errorf("weather API returned status %d", resp.StatusCode)
+// WithFetchTimeout sets the timeout for each upstream request made by the
+// default HTTP client. It has no effect with WithHTTPClient.
+func WithFetchTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.FetchTimeout = timeout
 	}
+}
 
-	var data openMeteoResponse
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return nil, nil, fmt.Errorf("decode weather: %w", err)
+// WithUserAgent sets the User-Agent sent to Open-Meteo, identifying this
+// deployment to it. Empty sends Go's default.
+func WithUserAgent(ua string) Option {
+	return func(s *Server) {
+		s.UserAgent = ua
 	}
+}
 
-	condition, emoji := weatherCodeToCondition(data.Current.WeatherCode, data.Current.IsDay == 1)
+// WithAPIToken requires API requests to send "Authorization: Bearer token".
+// An empty token leaves the API open.
+func WithAPIToken(token string) Option {
+	return func(s *Server) {
+		s.APIToken = token
+	}
+}
 
-	weather := &WeatherData{
-		Temperature:    data.Current.Temperature2m,
-		FeelsLike:      data.Current.ApparentTemp,
-		Humidity:       data.Current.RelativeHumidity,
-		WindSpeed:      data.Current.WindSpeed10m,
-		WindDirection:  data.Current.WindDirection10m,
-		WeatherCode:    data.Current.WeatherCode,
-		IsDay:          data.Current.IsDay == 1,
-		Precipitation:  data.Current.Precipitation,
-		CloudCover:     data.Current.CloudCover,
-		LastUpdated:    data.Current.Time,
-		Condition:      condition,
-		ConditionEmoji: emoji,
+// WithRateLimit limits each client IP to rate requests per second on the
+// weather endpoints, allowing bursts of up to burst. A rate of 0 disables
+// limiting.
+func WithRateLimit(rate float64, burst int) Option {
+	return func(s *Server) {
+		s.RateLimit = rate
+		s.RateBurst = burst
 	}
+}
+
+// WithTrustForwardedFor makes rate limiting and request logs use the
+// client address from X-Forwarded-For, for deployments behind a reverse
+// proxy that sets it.
+func WithTrustForwardedFor(trust bool) Option {
+	return func(s *Server) {
+		s.TrustForwardedFor = trust
+	}
+}
 
-	// Build hourly forecast
-	hourly := make([]HourlyForecast, 0, len(data.Hourly.Time))
-	for i, timeStr := range data.Hourly.Time {
-		if i >= len(data.Hourly.Temperature2m) || i >= len(data.Hourly.WeatherCode) {
-			break
+// WithTrustedProxies is WithTrustForwardedFor for deployments behind a
+// chain of n reverse proxies that each append to X-Forwarded-For, such as
+// a CDN in front of a load balancer.
+func WithTrustedProxies(n int) Option {
+	return func(s *Server) {
+		s.TrustForwardedFor = true
+		s.TrustedProxies = n
+	}
+}
+
+// newHTTPClient returns the default upstream client. It is shared across
+// requests so connections to Open-Meteo are kept alive and reused.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 10
+	transport.MaxIdleConnsPerHost = 10
+	transport.IdleConnTimeout = 90 * time.Second
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
+
+// defaultUserAgent names the app and its version: the module version when
+// built from a tagged release, else the VCS revision it was built from.
+func defaultUserAgent() string {
+	version := "dev"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v := info.Main.Version; v != "" && v != "(devel)" {
+			version = v
+		} else {
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+					version = setting.Value[:12]
+				}
+			}
 		}
-		isDay := false
-		if i < len(data.Hourly.IsDay) {
-			isDay = data.Hourly.IsDay[i] == 1
+	}
+	return "srv.exe.dev-weather/" + version
+}
+
+// New returns a server with the default settings for the database at
+// dbPath, showing hostname, with opts applied.
+func New(dbPath, hostname string, opts ...Option) (*Server, error) {
+	cfg := DefaultConfig()
+	cfg.DBPath = dbPath
+	cfg.Hostname = hostname
+	return NewWithConfig(cfg, opts...)
+}
+
+// NewWithConfig returns a server with the settings in cfg, after applying
+// opts, and opens its database unless WithStore gave it a store.
+func NewWithConfig(cfg Config, opts ...Option) (*Server, error) {
+	srv := &Server{
+		Config: cfg,
+		Now:    time.Now,
+	}
+	srv.Providers = []WeatherProvider{openMeteoProvider{srv}}
+	for _, opt := range opts {
+		opt(srv)
+	}
+	if err := srv.Config.check(); err != nil {
+		return nil, err
+	}
+	if srv.HTTPClient == nil {
+		srv.HTTPClient = newHTTPClient(srv.FetchTimeout)
+	}
+	tz, err := time.LoadLocation(srv.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("load timezone: %w", err)
+	}
+	srv.timezone = tz
+	if srv.DevMode {
+		// Read straight from the source tree, when there is one, so edits
+		// show up without rebuilding.
+		if srv.TemplatesDir == "" {
+			srv.TemplatesDir = sourceDir("templates")
 		}
-		_, hourEmoji := weatherCodeToCondition(data.Hourly.WeatherCode[i], isDay)
-		
-		// Parse time to get hour display
-		hourDisplay := timeStr
-		if t, err := time.Parse("2006-01-02T15:04", timeStr); err == nil {
-			hourDisplay = t.Format("3 PM")
+		if srv.StaticDir == "" {
+			srv.StaticDir = sourceDir("static")
 		}
-		
-		precipProb := 0
-		if i < len(data.Hourly.PrecipProb) {
-			precipProb = data.Hourly.PrecipProb[i]
+	}
+	templateFS, err := assetFS(srv.TemplatesDir, "templates")
+	if err != nil {
+		return nil, err
+	}
+	if srv.templates, err = srv.parseTemplates(templateFS); err != nil {
+		return nil, err
+	}
+	if srv.templates.Lookup(pageTemplate) == nil {
+		dir := srv.TemplatesDir
+		if dir == "" {
+			dir = "embedded templates"
 		}
-		
-		hourly = append(hourly, HourlyForecast{
-			Time:           timeStr,
-			Hour:           hourDisplay,
-			Temperature:    data.Hourly.Temperature2m[i],
-			WeatherCode:    data.Hourly.WeatherCode[i],
-			ConditionEmoji: hourEmoji,
-			PrecipProb:     precipProb,
-			IsDay:          isDay,
-		})
-	}
-
-	return weather, hourly, nil
-}
-
-func weatherCodeToCondition(code int, isDay bool) (string, string) {
-	switch code {
-	case 0:
-		if isDay {
-			return "Clear sky", "☀️"
+		return nil, fmt.Errorf("%s: no %s; check -templates-dir", dir, pageTemplate)
+	}
+	if srv.staticFS, err = assetFS(srv.StaticDir, "static"); err != nil {
+		return nil, err
+	}
+	if srv.Store == nil {
+		if srv.Store, err = openSQLStore(srv.DBPath); err != nil {
+			return nil, err
 		}
-		return "Clear sky", "🌙"
-	case 1:
-		if isDay {
-			return "Mainly clear", "🌤️"
+	}
+	return srv, nil
+}
+
+// maxHostnameLen is the longest hostname New accepts, the DNS limit.
+const maxHostnameLen = 253
+
+// normalizeHostname trims hostname and checks it is fit to show on the
+// page. An empty hostname is replaced with the system hostname.
+func normalizeHostname(hostname string) (string, error) {
+	hostname = strings.TrimSpace(hostname)
+	if hostname == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("hostname is empty and the system hostname is unavailable: %w", err)
 		}
-		return "Mainly clear", "🌙"
-	case 2:
-		return "Partly cloudy", "⛅"
-	case 3:
-		return "Overcast", "☁️"
-	case 45, 48:
-		return "Foggy", "🌫️"
-	case 51, 53, 55:
-		return "Drizzle", "🌧️"
-	case 56, 57:
-		return "Freezing drizzle", "🌧️❄️"
-	case 61, 63, 65:
-		return "Rain", "🌧️"
-	case 66, 67:
-		return "Freezing rain", "🌧️❄️"
-	case 71, 73, 75:
-		return "Snow", "🌨️"
-	case 77:
-		return "Snow grains", "🌨️"
-	case 80, 81, 82:
-		return "Rain showers", "🌦️"
-	case 85, 86:
-		return "Snow showers", "🌨️"
-	case 95:
-		return "Thunderstorm", "⛈️"
-	case 96, 99:
-		return "Thunderstorm with hail", "⛈️"
-	default:
-		return "Unknown", "❓"
-	}
-}
-
-func windDirectionToCompass(degrees int) string {
-	directions := []string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
-	index := int(float64(degrees)/22.5+0.5) % 16
-	return directions[index]
+		hostname = h
+	}
+	if len(hostname) > maxHostnameLen {
+		return "", fmt.Errorf("hostname is %d bytes, longer than the %d allowed", len(hostname), maxHostnameLen)
+	}
+	if !utf8.ValidString(hostname) {
+		return "", fmt.Errorf("hostname %q is not valid UTF-8", hostname)
+	}
+	for _, r := range hostname {
+		if unicode.IsControl(r) {
+			return "", fmt.Errorf("hostname %q contains control character %U", hostname, r)
+		}
+	}
+	return hostname, nil
+}
+
+func validateCoordinates(lat, lon float64) error {
+	if lat < -90 || lat > 90 {
+		return fmt.Errorf("latitude %v out of range [-90, 90]", lat)
+	}
+	if lon < -180 || lon > 180 {
+		return fmt.Errorf("longitude %v out of range [-180, 180]", lon)
+	}
+	return nil
 }
 
 func (s *Server) HandleRoot(w http.ResponseWriter, r *http.Request) {
-	now := time.Now()
+	now := s.Now()
+
+	units, err := parseUnitSystem(r.URL.Query().Get("units"))
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	step, err := parseHourlyStep(r.URL.Query().Get("step"))
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	theme, err := themeForRequest(w, r)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	locationName, query, err := s.locationForRequest(r, units)
+	switch {
+	case errors.Is(err, errEmptyPlaceQuery):
+		httpError(w, r, "Enter a place name or postal code", http.StatusBadRequest)
+		return
+	case errors.Is(err, errUnknownPlace):
+		httpError(w, r, "No place matches that search", http.StatusNotFound)
+		return
+	case errors.Is(err, sql.ErrNoRows):
+		httpError(w, r, "Unknown location", http.StatusNotFound)
+		return
+	case errors.Is(err, errGeocodingUnavailable):
+		slog.ErrorContext(r.Context(), "geocode", "error", err)
+		httpError(w, r, "Unable to look up that place right now", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "resolve location", "error", err)
+		httpError(w, r, "Unable to look up location", http.StatusInternalServerError)
+		return
+	}
 
 	data := pageData{
-		Hostname: s.Hostname,
-		Now:      now.Format(time.RFC3339),
+		Hostname:      s.Hostname,
+		Location:      locationName,
+		LocationParam: r.URL.Query().Get("location"),
+		PlaceQuery:    r.URL.Query().Get("q"),
+		Units:         units,
+		Theme:         theme,
+		Compact:       isCompact(r),
+		Source:        openMeteoSource,
+		Now:           now.In(s.timezone).Format(time.RFC3339),
+		Lang:          requestLanguage(r),
+		RequestID:     requestIDFrom(r.Context()),
 	}
+	data.MoonPhase, data.MoonEmoji = moonPhase(now)
 
-	weather, hourly, err := s.fetchWeather()
+	forecast, _, err := s.lookupForecast(r.Context(), query, s.forceRefresh(r, query))
 	if err != nil {
-		slog.Error("fetch weather", "error", err)
-		data.Error = "Unable to fetch weather data. Please try again later."
-	} else {
-		data.Weather = weather
-		data.Hourly = hourly
+		slog.ErrorContext(r.Context(), "fetch weather", "error", err)
+		// Fall back to the last good forecast, however old, rather than
+		// showing nothing.
+		if cached, fetchedAt, ok := s.cache.get(query, s.Now()); ok {
+			forecast = cached
+			data.StaleAsOf = fetchedAt.In(s.timezone).Format("Jan 2, 3:04 PM")
+		} else {
+			data.Error = "Unable to fetch weather data. Please try again later."
+		}
+	}
+	if forecast != nil && data.StaleAsOf == "" && s.Now().Sub(forecast.fetchedAt) > s.StaleOK {
+		// While the background refresher runs, lookups serve the cache
+		// without erroring however long upstream has been failing.
+		data.StaleAsOf = forecast.fetchedAt.In(s.timezone).Format("Jan 2, 3:04 PM")
+	}
+	if forecast != nil {
+		data.Weather = localizeCurrent(forecast.Current, data.Lang)
+		data.Hourly = s.upcomingHours(forecast.Hourly)
+		data.HourlyUnavailable = len(data.Hourly) == 0
+		data.RainChanceToday, data.HasRainChance = rainChanceToday(data.Hourly, now, s.timezone)
+		if len(data.Hourly) > 0 {
+			data.TempTrend = tempTrend(forecast.Current.Temperature, data.Hourly[1:])
+		}
+		data.Hourly = everyNthHour(data.Hourly, step)
+		data.Daily = localizeDaily(forecast.Daily, data.Lang)
+		data.PrecipTotal = forecast.PrecipTotal24h
+		if forecast.TodayHigh != nil && forecast.TodayLow != nil {
+			data.HasTodayRange = true
+			data.TodayHigh, data.TodayLow = *forecast.TodayHigh, *forecast.TodayLow
+		}
+		data.AirQuality = forecast.AirQuality
+		data.Alert = alertFor(forecast.Current, units)
 	}
 
+	name := pageTemplate
+	if data.Compact {
+		name = compactTemplate
+	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := s.renderTemplate(w, "weather.html", data); err != nil {
-		slog.Warn("render template", "url", r.URL.Path, "error", err)
+	if err := s.renderTemplate(w, name, data); err != nil {
+		slog.WarnContext(r.Context(), "render template", "url", r.URL.Path, "error", err)
 	}
 }
 
-func (s *Server) HandleAPI(w http.ResponseWriter, r *http.Request) {
-	weather, hourly, err := s.fetchWeather()
-	if err != nil {
-		slog.Error("fetch weather", "error", err)
-		http.Error(w, "Unable to fetch weather", http.StatusServiceUnavailable)
-		return
+// HandleHealth reports whether the process is up and can reach its
+// database, along with how upstream fetches have been going. It never
+// calls Open-Meteo, so it is cheap enough for load balancer health checks.
+// After degradedAfterFailures failed fetches in a row the status is
+// "degraded", but still 200, since the server itself is up and can serve
+// cached data.
+func (s *Server) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	failures, lastSuccess := s.fetchHealth.snapshot()
+	status := struct {
+		Status   string `json:"status"`
+		Database string `json:"database"`
+		// LastFetchSuccess is null until a fetch has succeeded.
+		LastFetchSuccess         *time.Time `json:"last_fetch_success"`
+		ConsecutiveFetchFailures int        `json:"consecutive_fetch_failures"`
+	}{Status: "ok", Database: "ok", ConsecutiveFetchFailures: failures}
+	if !lastSuccess.IsZero() {
+		status.LastFetchSuccess = &lastSuccess
 	}
-
-	response := struct {
-		Current *WeatherData     `json:"current"`
-		Hourly  []HourlyForecast `json:"hourly"`
-	}{
-		Current: weather,
-		Hourly:  hourly,
+	if failures >= degradedAfterFailures {
+		status.Status = "degraded"
+	}
+	code := http.StatusOK
+	if err := s.Store.Ping(r.Context()); err != nil {
+		slog.WarnContext(r.Context(), "health check: ping db", "error", err)
+		status.Status = "unavailable"
+		status.Database = "unreachable"
+		code = http.StatusServiceUnavailable
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
 }
 
-func (s *Server) renderTemplate(w http.ResponseWriter, name string, data any) error {
-	path := filepath.Join(s.TemplatesDir, name)
+// parseTemplates parses every .html template in fsys, so syntax errors are
+// reported at startup rather than on the first request.
+func (s *Server) parseTemplates(fsys fs.FS) (*template.Template, error) {
 	funcs := template.FuncMap{
 		"windDir": windDirectionToCompass,
+		"precip":  formatPrecipitation,
+		"relativeTime": func(v string) string {
+			return relativeTime(v, s.Now(), s.timezone)
+		},
 	}
-	tmpl, err := template.New(name).Funcs(funcs).ParseFiles(path)
+	tmpl, err := template.New("").Funcs(funcs).ParseFS(fsys, "*.html")
 	if err != nil {
-		return fmt.Errorf("parse template %q: %w", name, err)
+		return nil, fmt.Errorf("parse templates: %w", err)
 	}
-	if err := tmpl.Execute(w, data); err != nil {
-		return fmt.Errorf("execute template %q: %w", name, err)
+	return tmpl, nil
+}
+
+// isCompact reports whether r asks for the compact page, which other
+// sites may embed in an iframe.
+func isCompact(r *http.Request) bool {
+	return r.URL.Path == "/" && r.URL.Query().Get("compact") == "1"
+}
+
+// pageTemplate is the template HandleRoot renders, and compactTemplate the
+// one it renders for ?compact=1.
+const (
+	pageTemplate    = "weather.html"
+	compactTemplate = "weather_compact.html"
+)
+
+// fallbackPage is rendered when a page template fails, so visitors still
+// get the weather while the templates are fixed.
+var fallbackPage = template.Must(template.New("fallback").Parse(`<!doctype html>
+<meta charset="utf-8">
+<title>{{.Location}} Weather</title>
+<h1>{{.Location}}</h1>
+{{with .Weather}}<p>{{printf "%.0f" .Temperature}}{{.Units.Temperature}}, {{.Condition}}</p>{{end}}
+{{with .Error}}<p>{{.}}</p>{{end}}
+{{with .RequestID}}<p>Request ID: {{.}}</p>{{end}}
+`))
+
+// renderTemplate executes the named template with data. If that fails,
+// it writes fallbackPage instead, with status 500, and returns the error.
+// The page is buffered so a failure partway through doesn't leave half of
+// it on the wire.
+func (s *Server) renderTemplate(w http.ResponseWriter, name string, data any) error {
+	var buf bytes.Buffer
+	err := s.templates.ExecuteTemplate(&buf, name, data)
+	if err == nil {
+		_, err = buf.WriteTo(w)
+		return err
 	}
-	return nil
+	err = fmt.Errorf("execute template %q: %w", name, err)
+	buf.Reset()
+	if ferr := fallbackPage.Execute(&buf, data); ferr != nil {
+		http.Error(w, "Unable to render page", http.StatusInternalServerError)
+		return errors.Join(err, fmt.Errorf("execute fallback page: %w", ferr))
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	buf.WriteTo(w)
+	return err
 }
 
-// SetupDatabase initializes the database connection and runs migrations
-func (s *Server) setUpDatabase(dbPath string) error {
-	wdb, err := db.Open(dbPath)
+// Serve listens on addr and serves until SIGINT or SIGTERM, then drains
+// in-flight requests and closes the database.
+func (s *Server) Serve(addr string) error {
+	ln, err := Listen(addr)
 	if err != nil {
-		return fmt.Errorf("failed to open db: %w", err)
+		return err
 	}
-	s.DB = wdb
-	if err := db.RunMigrations(wdb); err != nil {
-		return fmt.Errorf("failed to run migrations: %w", err)
+	return s.ServeListener(ln)
+}
+
+// ServeListener is Serve on an existing listener. Callers that bind to
+// port 0 can use Listen and read the chosen port from ln.Addr() first.
+func (s *Server) ServeListener(ln net.Listener) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return s.serve(ctx, ln)
+}
+
+// Listen checks that addr is a valid host:port and binds it. Port 0 picks
+// a free port.
+func Listen(addr string) (net.Listener, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %w", addr, err)
 	}
-	return nil
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return nil, fmt.Errorf("invalid listen address %q: port must be a number from 0 to 65535", addr)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", addr, err)
+	}
+	return ln, nil
 }
 
-// Serve starts the HTTP server with the configured routes
-func (s *Server) Serve(addr string) error {
+// serve runs the HTTP server on ln until ctx is done and then shuts it
+// down.
+func (s *Server) serve(ctx context.Context, ln net.Listener) error {
+	httpServer := &http.Server{
+		Handler: s.routes(),
+	}
+	httpServer.RegisterOnShutdown(s.updates.close)
+	refreshCtx, stopRefresher := context.WithCancel(ctx)
+	refresherDone := s.startRefresher(refreshCtx, s.RefreshInterval)
+
+	errc := make(chan error, 1)
+	go func() {
+		slog.Info("starting server", "addr", ln.Addr().String())
+		errc <- httpServer.Serve(ln)
+	}()
+
+	select {
+	case err := <-errc:
+		stopRefresher()
+		<-refresherDone
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := httpServer.Shutdown(shutdownCtx)
+	stopRefresher()
+	<-refresherDone
+	if cerr := s.Store.Close(); cerr != nil {
+		err = errors.Join(err, fmt.Errorf("close db: %w", cerr))
+	}
+	return err
+}
+
+// apiVersion is the current version of the weather JSON API. Its
+// response shapes are frozen: fields may be added but are never renamed,
+// removed or retyped. Breaking changes go under /api/v2/, which is
+// reserved for them.
+const apiVersion = "v1"
+
+// handleVersioned registers h for method at /api/<version>/path and at
+// the unversioned /api/path, which stays an alias of the current version
+// for existing clients.
+func handleVersioned(mux *http.ServeMux, method, path string, h http.Handler) {
+	mux.Handle(method+" /api/"+apiVersion+path, h)
+	mux.Handle(method+" /api"+path, h)
+}
+
+// routes returns the handler for all of the server's endpoints.
+func (s *Server) routes() http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /{$}", s.HandleRoot)
-	mux.HandleFunc("GET /api/weather", s.HandleAPI)
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(s.StaticDir))))
-	slog.Info("starting server", "addr", addr)
-	return http.ListenAndServe(addr, mux)
+	// The page can fetch a forecast and geocode ?q=, so it shares the API's limiter.
+	mux.Handle("GET /{$}", gzipResponses(s.rateLimit(http.HandlerFunc(s.HandleRoot))))
+	api := func(h http.HandlerFunc) http.Handler { return s.allowCORS(s.requireAPIToken(gzipResponses(h))) }
+	// The weather endpoints can trigger upstream fetches, so they are rate limited.
+	limited := func(h http.HandlerFunc) http.Handler { return api(s.rateLimit(h).ServeHTTP) }
+	handleVersioned(mux, "GET", "/weather", limited(s.HandleAPI))
+	handleVersioned(mux, "GET", "/weather/current", limited(s.HandleAPICurrent))
+	handleVersioned(mux, "GET", "/weather/hourly", limited(s.HandleHourly))
+	mux.Handle("GET /api/weather.csv", limited(s.HandleCSV))
+	mux.Handle("GET /api/raw", limited(s.HandleRaw))
+	// Streams are flushed event by event, so they skip gzip.
+	mux.Handle("GET /api/weather/stream", s.allowCORS(s.requireAPIToken(s.rateLimit(http.HandlerFunc(s.HandleStream)))))
+	mux.Handle("GET /api/history", api(s.HandleHistory))
+	mux.Handle("GET /api/conditions", api(s.HandleConditions))
+	mux.Handle("GET /api/locations", api(s.HandleListLocations))
+	mux.Handle("POST /api/locations", api(s.HandleAddLocation))
+	// The description is public so client generators can fetch it without a token.
+	mux.Handle("GET /api/openapi.json", s.allowCORS(gzipResponses(http.HandlerFunc(s.HandleOpenAPI))))
+	// Browsers send preflights without credentials, so they skip the token check.
+	preflight := s.allowCORS(http.HandlerFunc(s.HandlePreflight))
+	for _, path := range []string{"/weather", "/weather/current", "/weather/hourly"} {
+		handleVersioned(mux, "OPTIONS", path, preflight)
+	}
+	for _, path := range []string{"/api/weather/stream", "/api/weather.csv", "/api/raw", "/api/history", "/api/conditions", "/api/locations", "/api/openapi.json"} {
+		mux.Handle("OPTIONS "+path, preflight)
+	}
+	mux.Handle("POST /admin/cache/flush", s.requireAPIToken(http.HandlerFunc(s.HandleFlushCache)))
+	mux.HandleFunc("GET /healthz", s.HandleHealth)
+	mux.HandleFunc("GET /metrics", s.HandleMetrics)
+	static := s.staticHandler()
+	mux.Handle("/static/", http.StripPrefix("/static/", static))
+	// Browsers look for these at the root whatever the page links to.
+	for _, name := range []string{"favicon.ico", "site.webmanifest"} {
+		mux.Handle("GET /"+name, static)
+	}
+	return withRequestID(s.logRequests(s.countRequests(s.securityHeaders(recoverPanics(mux)))))
 }
@@ -1,117 +1,633 @@
 package srv
 
 import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
-func TestServerSetupAndHandlers(t *testing.T) {
-	tempDB := filepath.Join(t.TempDir(), "test_server.sqlite3")
-	t.Cleanup(func() { os.Remove(tempDB) })
+const stubForecastJSON = `{
+  "current": {
+    "time": "2025-01-15T14:00",
+    "temperature_2m": 41.3,
+    "apparent_temperature": 35.6,
+    "relative_humidity_2m": 62,
+    "wind_speed_10m": 9.4,
+    "wind_direction_10m": 270,
+    "weather_code": 3,
+    "is_day": 1,
+    "precipitation": 0,
+    "cloud_cover": 90,
+    "uv_index": 1.45,
+    "surface_pressure": 1016.0,
+    "dew_point_2m": 29.1,
+    "visibility": 16093.44
+  },
+  "hourly": {
+    "time": ["2025-01-15T14:00", "2025-01-15T15:00", "2025-01-15T16:00"],
+    "temperature_2m": [41.3, 40.8, 39.9],
+    "weather_code": [3, 3, 61],
+    "precipitation_probability": [5, 10, 40],
+    "is_day": [1, 1, 0]
+  },
+  "daily": {
+    "time": ["2025-01-15", "2025-01-16", "2025-01-17"],
+    "temperature_2m_max": [43.1, 38.2, 45.0],
+    "temperature_2m_min": [31.4, 29.9, 33.3],
+    "weather_code": [3, 71, 0],
+    "precipitation_probability_max": [40, 80, 0],
+    "sunrise": ["2025-01-15T07:18", "2025-01-16T07:18", "2025-01-17T07:17"],
+    "sunset": ["2025-01-15T16:51", "2025-01-16T16:52", "2025-01-17T16:53"]
+  }
+}`
+
+const stubAirQualityJSON = `{
+  "current": {
+    "time": "2025-01-15T14:00",
+    "pm2_5": 8.4,
+    "pm10": 14.2,
+    "us_aqi": 42
+  }
+}`
+
+// stubNow is a time partway through the first hour of stubForecastJSON
+// (2:20 PM in New York), used as the clock of stub servers.
+var stubNow = time.Date(2025, 1, 15, 19, 20, 0, 0, time.UTC)
 
-	server, err := New(tempDB, "test-hostname")
+// newStubServer starts a fake Open-Meteo endpoint that answers with body
+// and returns a Server pointed at it plus a count of upstream forecast
+// requests. Air quality is served by a separate stub with
+// stubAirQualityJSON.
+func newStubServer(t *testing.T, body string, opts ...Option) (*Server, *atomic.Int64) {
+	t.Helper()
+	var hits atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(upstream.Close)
+	airQuality := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(stubAirQualityJSON))
+	}))
+	t.Cleanup(airQuality.Close)
+
+	opts = append([]Option{WithForecastURL(upstream.URL), WithAirQualityURL(airQuality.URL)}, opts...)
+	server, err := New(filepath.Join(t.TempDir(), "test_server.sqlite3"), "test-hostname", opts...)
 	if err != nil {
 		t.Fatalf("failed to create server: %v", err)
 	}
+	server.Now = func() time.Time { return stubNow }
+	return server, &hits
+}
 
-	// Test root endpoint without auth
-	t.Run("root endpoint unauthenticated", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/", nil)
-		w := httptest.NewRecorder()
+// renderRoot runs HandleRoot for target and returns the response, failing
+// the test unless the page rendered.
+func renderRoot(t *testing.T, server *Server, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	server.HandleRoot(w, httptest.NewRequest(http.MethodGet, target, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: expected status 200, got %d: %s", target, w.Code, w.Body.String())
+	}
+	return w
+}
 
-		server.HandleRoot(w, req)
+// advanceClock moves the server's clock forward by d.
+func advanceClock(s *Server, d time.Duration) {
+	now := s.Now().Add(d)
+	s.Now = func() time.Time { return now }
+}
 
-		if w.Code != http.StatusOK {
-			t.Errorf("expected status 200, got %d", w.Code)
-		}
+func TestServerSetupAndHandlers(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
 
-		body := w.Body.String()
-		if !strings.Contains(body, "test-hostname") {
-			t.Errorf("expected page to show hostname, got body: %s", body)
+	t.Run("root endpoint renders", func(t *testing.T) {
+		body := renderRoot(t, server, "/").Body.String()
+		if !strings.Contains(body, `<div class="temperature">41°F</div>`) {
+			t.Errorf("expected the stub temperature, got body: %s", body)
 		}
-		if !strings.Contains(body, "Go Template Project") {
+		if !strings.Contains(body, "Brooklyn, NY") {
 			t.Errorf("expected page to contain headline, got body: %s", body)
 		}
-		if strings.Contains(body, "Signed in as") {
-			t.Errorf("expected page to not be logged in, got body: %s", body)
+		if !strings.Contains(body, "Open-Meteo") {
+			t.Errorf("expected page to credit Open-Meteo, got body: %s", body)
+		}
+		if !strings.Contains(body, "Last updated 20 minutes ago") {
+			t.Errorf("expected a relative update time, got body: %s", body)
+		}
+	})
+
+	t.Run("metric", func(t *testing.T) {
+		body := renderRoot(t, server, "/?units=metric").Body.String()
+		if !strings.Contains(body, "°C</div>") {
+			t.Errorf("expected Celsius on the page, got body: %s", body)
+		}
+	})
+
+	t.Run("over HTTP", func(t *testing.T) {
+		// Through a real listener and the full middleware stack, as a
+		// browser would see it.
+		ts := httptest.NewServer(server.routes())
+		defer ts.Close()
+		resp, err := ts.Client().Get(ts.URL + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+			t.Fatalf("expected an HTML page, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		if !strings.Contains(string(body), `<div class="temperature">41°F</div>`) {
+			t.Errorf("expected the stub temperature, got body: %s", body)
 		}
-		if !strings.Contains(body, "Not signed in") {
-			t.Errorf("expected page to show 'Not signed in', got body: %s", body)
+		if resp.Header.Get("X-Request-Id") == "" {
+			t.Error("expected the middleware to assign a request ID")
 		}
 	})
+}
+
+func TestNewLocation(t *testing.T) {
+	t.Run("defaults to Brooklyn", func(t *testing.T) {
+		server, err := New(filepath.Join(t.TempDir(), "db.sqlite3"), "test-hostname")
+		if err != nil {
+			t.Fatalf("failed to create server: %v", err)
+		}
+		if server.Lat != brooklynLat || server.Lon != brooklynLon {
+			t.Errorf("expected Brooklyn coordinates, got %v,%v", server.Lat, server.Lon)
+		}
+	})
+
+	t.Run("custom location", func(t *testing.T) {
+		server, err := New(filepath.Join(t.TempDir(), "db.sqlite3"), "test-hostname", WithLocation("Paris", 48.8566, 2.3522))
+		if err != nil {
+			t.Fatalf("failed to create server: %v", err)
+		}
+		if server.LocationName != "Paris" || server.Lat != 48.8566 || server.Lon != 2.3522 {
+			t.Errorf("location not applied: %+v", server)
+		}
+	})
+
+	t.Run("rejects out of range coordinates", func(t *testing.T) {
+		tests := []struct {
+			lat, lon float64
+		}{
+			{91, 0},
+			{-90.5, 0},
+			{0, 180.1},
+			{0, -181},
+		}
+		for _, test := range tests {
+			_, err := New(filepath.Join(t.TempDir(), "db.sqlite3"), "test-hostname", WithLocation("Nowhere", test.lat, test.lon))
+			if err == nil {
+				t.Errorf("expected error for %v,%v", test.lat, test.lon)
+			}
+		}
+	})
+}
+
+func TestNewHostname(t *testing.T) {
+	newServer := func(hostname string) (*Server, error) {
+		return New(filepath.Join(t.TempDir(), "db.sqlite3"), hostname)
+	}
+
+	server, err := newServer("  weather-box  ")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if server.Hostname != "weather-box" {
+		t.Errorf("expected trimmed hostname, got %q", server.Hostname)
+	}
+
+	system, _ := os.Hostname()
+	server, err = newServer("")
+	if err != nil {
+		t.Fatalf("New with empty hostname: %v", err)
+	}
+	if server.Hostname != system {
+		t.Errorf("expected system hostname %q, got %q", system, server.Hostname)
+	}
+
+	for _, bad := range []string{strings.Repeat("a", maxHostnameLen+1), "evil\nhost", "bell\x07", "\xff\xfe"} {
+		if _, err := newServer(bad); err == nil {
+			t.Errorf("expected an error for hostname %q", bad)
+		}
+	}
+}
+
+func TestTimezone(t *testing.T) {
+	var gotTimezone string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTimezone = r.URL.Query().Get("timezone")
+		w.Write([]byte(stubForecastJSON))
+	}))
+	defer upstream.Close()
+
+	server, _ := newStubServer(t, stubForecastJSON, WithForecastURL(upstream.URL), WithTimezone("Europe/Paris"))
+	forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if gotTimezone != "Europe/Paris" {
+		t.Errorf("expected timezone=Europe/Paris upstream, got %q", gotTimezone)
+	}
+	// 19:20 UTC is 20:20 in Paris, past every stub hour.
+	if hourly := server.upcomingHours(forecast.Hourly); len(hourly) != 0 {
+		t.Errorf("expected no upcoming hours in Paris time, got %v", hourly)
+	}
+
+	for _, tz := range []string{"", "Local", "Mars/Olympus_Mons"} {
+		if _, err := New(filepath.Join(t.TempDir(), "db.sqlite3"), "test-hostname", WithTimezone(tz)); err == nil {
+			t.Errorf("expected an error for timezone %q", tz)
+		}
+	}
+}
+
+func TestParseTemplates(t *testing.T) {
+	t.Run("repo templates", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON)
+		if server.templates.Lookup("weather.html") == nil {
+			t.Fatal("expected weather.html to be parsed at startup")
+		}
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON)
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "broken.html"), []byte("{{if .Weather}}unclosed"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.parseTemplates(os.DirFS(dir)); err == nil {
+			t.Error("expected an error for a template with a syntax error")
+		}
+	})
+}
+
+func TestRenderTemplateFallback(t *testing.T) {
+	dir := t.TempDir()
+	page := `<h1>{{.Location}}</h1>{{.NoSuchField}}`
+	if err := os.WriteFile(filepath.Join(dir, pageTemplate), []byte(page), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	server, _ := newStubServer(t, stubForecastJSON, WithTemplatesDir(dir))
+	logs := captureLogs(t)
+
+	w := httptest.NewRecorder()
+	server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500, got %d", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "<h1>Brooklyn, NY</h1>") || !strings.Contains(body, "41°F, Overcast") {
+		t.Errorf("expected the fallback page with the weather, got body: %s", body)
+	}
+	if strings.Count(body, "<h1>") != 1 {
+		t.Errorf("expected none of the failed page to be sent, got body: %s", body)
+	}
+	if !strings.Contains(logs.String(), "NoSuchField") {
+		t.Errorf("expected the template error to be logged, got %q", logs.String())
+	}
+}
+
+func TestServeShutdown(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ln, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- server.serve(ctx, ln) }()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatalf("request to chosen port %s: %v", ln.Addr(), err)
+	}
+	resp.Body.Close()
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected clean shutdown, got %v", err)
+		}
+	case <-time.After(shutdownTimeout):
+		t.Fatal("serve did not return within the shutdown grace period")
+	}
+	if err := server.Store.Ping(context.Background()); err == nil {
+		t.Error("expected database to be closed after shutdown")
+	}
+}
+
+func TestListen(t *testing.T) {
+	for _, addr := range []string{"8000", "localhost", ":http", ":70000", "::1:8000"} {
+		if ln, err := Listen(addr); err == nil {
+			ln.Close()
+			t.Errorf("Listen(%q): expected an error", addr)
+		} else if !strings.Contains(err.Error(), "invalid listen address") {
+			t.Errorf("Listen(%q): expected an invalid address error, got %v", addr, err)
+		}
+	}
+
+	ln, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if port := ln.Addr().(*net.TCPAddr).Port; port == 0 {
+		t.Error("expected port 0 to be replaced with a free port")
+	}
+}
+
+func TestHandleHealth(t *testing.T) {
+	server, hits := newStubServer(t, stubForecastJSON)
 
-	// Test root endpoint with auth headers
-	t.Run("root endpoint authenticated", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/", nil)
-		req.Header.Set("X-ExeDev-UserID", "user123")
-		req.Header.Set("X-ExeDev-Email", "test@example.com")
+	check := func(t *testing.T, expectedCode int, expectedStatus string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
 		w := httptest.NewRecorder()
+		server.routes().ServeHTTP(w, req)
+		if w.Code != expectedCode {
+			t.Errorf("expected status %d, got %d", expectedCode, w.Code)
+		}
+		var body struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode health: %v", err)
+		}
+		if body.Status != expectedStatus {
+			t.Errorf("expected status %q, got %q", expectedStatus, body.Status)
+		}
+	}
+
+	t.Run("healthy", func(t *testing.T) {
+		check(t, http.StatusOK, "ok")
+	})
+
+	t.Run("database down", func(t *testing.T) {
+		server.Store.Close()
+		check(t, http.StatusServiceUnavailable, "unavailable")
+	})
+
+	if n := hits.Load(); n != 0 {
+		t.Errorf("expected no upstream requests, got %d", n)
+	}
+}
 
-		server.HandleRoot(w, req)
+func TestHandleHealthFetchFailures(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	server, _ := newStubServer(t, stubForecastJSON)
 
+	health := func(t *testing.T) (status string, failures int, lastSuccess *time.Time) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.HandleHealth(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
 		if w.Code != http.StatusOK {
 			t.Errorf("expected status 200, got %d", w.Code)
 		}
+		var body struct {
+			Status                   string     `json:"status"`
+			LastFetchSuccess         *time.Time `json:"last_fetch_success"`
+			ConsecutiveFetchFailures int        `json:"consecutive_fetch_failures"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode health: %v", err)
+		}
+		return body.Status, body.ConsecutiveFetchFailures, body.LastFetchSuccess
+	}
 
-		body := w.Body.String()
-		if !strings.Contains(body, "Signed in as") {
-			t.Errorf("expected page to show logged in state, got body: %s", body)
+	if _, _, last := health(t); last != nil {
+		t.Errorf("expected no last success before any fetch, got %v", last)
+	}
+	if _, err := server.refreshForecast(context.Background(), server.defaultQuery(Imperial)); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if _, _, last := health(t); last == nil || !last.Equal(stubNow) {
+		t.Errorf("expected last success at %v, got %v", stubNow, last)
+	}
+
+	server.ForecastURL = failing.URL
+	for i := 1; i <= degradedAfterFailures; i++ {
+		server.refreshForecast(context.Background(), server.defaultQuery(Imperial))
+		status, failures, last := health(t)
+		want := "ok"
+		if i >= degradedAfterFailures {
+			want = "degraded"
 		}
-		if !strings.Contains(body, "test@example.com") {
-			t.Error("expected page to show user email")
+		if status != want || failures != i || last == nil {
+			t.Errorf("after %d failures: status %q, %d failures, last success %v; want %q, %d, %v", i, status, failures, last, want, i, stubNow)
 		}
-	})
+	}
+}
+
+func TestNewDefaultHTTPClient(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	if server.HTTPClient == nil {
+		t.Fatal("expected a default HTTP client")
+	}
+	if server.HTTPClient.Timeout != defaultFetchTimeout {
+		t.Errorf("expected timeout %v, got %v", defaultFetchTimeout, server.HTTPClient.Timeout)
+	}
+	transport, ok := server.HTTPClient.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("expected *http.Transport, got %T", server.HTTPClient.Transport)
+	}
+	if transport.IdleConnTimeout == 0 || transport.MaxIdleConnsPerHost == 0 {
+		t.Errorf("expected keepalive settings on transport, got %+v", transport)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON, WithFetchTimeout(3*time.Second))
+	if server.HTTPClient.Timeout != 3*time.Second {
+		t.Errorf("expected timeout 3s, got %v", server.HTTPClient.Timeout)
+	}
+
+	for _, timeout := range []time.Duration{0, -time.Second} {
+		_, err := New(filepath.Join(t.TempDir(), "db.sqlite3"), "test-hostname", WithFetchTimeout(timeout))
+		if err == nil {
+			t.Errorf("expected error for fetch timeout %v", timeout)
+		}
+	}
 
-	// Test view counter functionality
-	t.Run("view counter increments", func(t *testing.T) {
-		// Make first request
-		req1 := httptest.NewRequest(http.MethodGet, "/", nil)
-		req1.Header.Set("X-ExeDev-UserID", "counter-test")
-		req1.RemoteAddr = "192.168.1.100:12345"
-		w1 := httptest.NewRecorder()
-		server.HandleRoot(w1, req1)
-
-		// Should show "1 times" or similar
-		body1 := w1.Body.String()
-		if !strings.Contains(body1, "1</strong> times") {
-			t.Error("expected first visit to show 1 time")
-		}
-
-		// Make second request with same user
-		req2 := httptest.NewRequest(http.MethodGet, "/", nil)
-		req2.Header.Set("X-ExeDev-UserID", "counter-test")
-		req2.RemoteAddr = "192.168.1.100:12345"
-		w2 := httptest.NewRecorder()
-		server.HandleRoot(w2, req2)
-
-		// Should show "2 times" or similar
-		body2 := w2.Body.String()
-		if !strings.Contains(body2, "2</strong> times") {
-			t.Error("expected second visit to show 2 times")
+	t.Run("slow upstream", func(t *testing.T) {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}))
+		t.Cleanup(slow.Close)
+		server, _ := newStubServer(t, stubForecastJSON, WithForecastURL(slow.URL), WithFetchTimeout(20*time.Millisecond))
+		server.MaxRetries = 0
+		start := time.Now()
+		if _, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial)); err == nil {
+			t.Fatal("expected a timeout error")
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("expected the fetch to give up quickly, took %v", elapsed)
 		}
 	})
 }
 
-func TestUtilityFunctions(t *testing.T) {
-	t.Run("mainDomainFromHost function", func(t *testing.T) {
-		tests := []struct {
-			input    string
-			expected string
-		}{
-			{"example.exe.cloud:8080", "exe.cloud:8080"},
-			{"example.exe.dev", "exe.dev"},
-			{"example.exe.cloud", "exe.cloud"},
+func TestForecastHours(t *testing.T) {
+	var gotHours string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHours = r.URL.Query().Get("forecast_hours")
+		w.Write([]byte(stubForecastJSON))
+	}))
+	defer upstream.Close()
+
+	server, _ := newStubServer(t, stubForecastJSON, WithForecastURL(upstream.URL), WithForecastHours(72))
+	if _, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial)); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if gotHours != "72" {
+		t.Errorf("expected forecast_hours=72 upstream, got %q", gotHours)
+	}
+
+	for _, hours := range []int{0, -1, 169} {
+		_, err := New(filepath.Join(t.TempDir(), "db.sqlite3"), "test-hostname", WithForecastHours(hours))
+		if err == nil {
+			t.Errorf("expected error for forecast hours %d", hours)
 		}
+	}
+}
 
-		for _, test := range tests {
-			result := mainDomainFromHost(test.input)
-			if result != test.expected {
-				t.Errorf("mainDomainFromHost(%q) = %q, expected %q", test.input, result, test.expected)
-			}
+func TestHandleRootHourlyStep(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+
+	w := httptest.NewRecorder()
+	server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/?step=2", nil))
+	if n := strings.Count(w.Body.String(), `class="hour-card"`); n != 2 {
+		t.Errorf("expected 2 hour cards with step=2, got %d", n)
+	}
+
+	w = httptest.NewRecorder()
+	server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/?step=0", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for step=0, got %d", w.Code)
+	}
+}
+
+func TestHandleRootCompact(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+
+	w := renderRoot(t, server, "/?compact=1")
+	body := w.Body.String()
+	for _, want := range []string{`<body class="compact">`, `<span class="compact-temp">41°F</span>`, "Overcast", "☁️"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q on the compact page, got %s", want, body)
+		}
+	}
+	for _, unwanted := range []string{`class="hour-card"`, "<footer>", "refresh-btn"} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("expected no %q on the compact page", unwanted)
+		}
+	}
+
+	w = renderRoot(t, server, "/")
+	if strings.Contains(w.Body.String(), `class="compact"`) {
+		t.Error("expected the full page without compact mode")
+	}
+}
+
+func TestHandleRootEmptyHourly(t *testing.T) {
+	body := `{
+  "current": {"time": "2025-01-15T14:00", "temperature_2m": 41.3, "weather_code": 3, "is_day": 1},
+  "hourly": {"time": [], "temperature_2m": [], "weather_code": [], "precipitation_probability": [], "is_day": []}
+}`
+	server, _ := newStubServer(t, body)
+	page := renderRoot(t, server, "/").Body.String()
+	if !strings.Contains(page, `<div class="temperature">41°F</div>`) {
+		t.Errorf("expected current conditions despite the missing hourly data, got %s", page)
+	}
+	if !strings.Contains(page, "Hourly forecast unavailable") {
+		t.Errorf("expected an unavailable notice, got %s", page)
+	}
+	if strings.Contains(page, `class="hour-card"`) {
+		t.Errorf("expected no hour cards, got %s", page)
+	}
+
+	server, _ = newStubServer(t, stubForecastJSON)
+	if page := renderRoot(t, server, "/").Body.String(); strings.Contains(page, "Hourly forecast unavailable") {
+		t.Error("expected no unavailable notice with hourly data")
+	}
+}
+
+func TestHandleRootStaleFallback(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failing.Close()
+
+	t.Run("serves last good data", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON)
+		if _, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial)); err != nil {
+			t.Fatalf("warm cache: %v", err)
+		}
+		server.ForecastURL = failing.URL
+		advanceClock(server, 2*defaultCacheTTL)
+
+		w := httptest.NewRecorder()
+		server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		body := w.Body.String()
+		if !strings.Contains(body, "41°F") {
+			t.Errorf("expected cached temperature, got body: %s", body)
+		}
+		if !strings.Contains(body, "Showing data from Jan 15, 2:20 PM") {
+			t.Errorf("expected stale banner with the fetch time, got body: %s", body)
+		}
+		if strings.Contains(body, "Unable to fetch weather data") {
+			t.Errorf("expected no hard error with cached data, got body: %s", body)
+		}
+	})
+
+	t.Run("refresher running", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON)
+		if _, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial)); err != nil {
+			t.Fatalf("warm cache: %v", err)
+		}
+		server.ForecastURL = failing.URL
+		server.refreshing.Store(true)
+
+		advanceClock(server, defaultStaleOK)
+		if body := renderRoot(t, server, "/").Body.String(); strings.Contains(body, "Showing data from") {
+			t.Errorf("expected no stale banner while the forecast is recent enough, got body: %s", body)
+		}
+
+		advanceClock(server, 2*time.Hour)
+		body := renderRoot(t, server, "/").Body.String()
+		if !strings.Contains(body, "41°F") || !strings.Contains(body, "Showing data from Jan 15, 2:20 PM") {
+			t.Errorf("expected the cached forecast with a stale banner, got body: %s", body)
+		}
+	})
+
+	t.Run("hard error without cache", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON, WithForecastURL(failing.URL))
+		w := httptest.NewRecorder()
+		server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if !strings.Contains(w.Body.String(), "Unable to fetch weather data") {
+			t.Errorf("expected error message, got body: %s", w.Body.String())
 		}
 	})
 }
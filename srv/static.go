@@ -0,0 +1,98 @@
+package srv
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// defaultStaticMaxAge is how long browsers may reuse static assets without
+// revalidating. Asset names aren't fingerprinted, so it is kept short.
+const defaultStaticMaxAge = 3600 // seconds
+
+// WithStaticMaxAge sets the Cache-Control max-age, in seconds, for static
+// assets.
+func WithStaticMaxAge(seconds int) Option {
+	return func(s *Server) {
+		s.StaticMaxAge = seconds
+	}
+}
+
+// WithDevMode makes browsers revalidate static assets on every load, so
+// edits show up immediately.
+func WithDevMode(dev bool) Option {
+	return func(s *Server) {
+		s.DevMode = dev
+	}
+}
+
+// staticContentTypes covers static file extensions missing from Go's
+// built-in MIME table, which would otherwise depend on the host's.
+var staticContentTypes = map[string]string{
+	".ico":         "image/x-icon",
+	".webmanifest": "application/manifest+json",
+}
+
+// staticHandler serves the static assets with caching headers.
+// http.FileServer already sets Last-Modified when it is known; this adds
+// Cache-Control and an ETag, which FileServer then uses to answer
+// If-None-Match with 304.
+func (s *Server) staticHandler() http.Handler {
+	files := http.FileServerFS(s.staticFS)
+	etags := contentETags(s.staticFS)
+	cacheControl := "public, max-age=" + strconv.Itoa(s.StaticMaxAge)
+	if s.DevMode {
+		cacheControl = "no-cache"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if info, err := fs.Stat(s.staticFS, name); err == nil && !info.IsDir() {
+			if etag, ok := staticETag(etags, name, info); ok {
+				w.Header().Set("Cache-Control", cacheControl)
+				w.Header().Set("ETag", etag)
+			}
+			if ct, ok := staticContentTypes[path.Ext(name)]; ok {
+				w.Header().Set("Content-Type", ct)
+			}
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// contentETags hashes the files in fsys that have no modification time,
+// which are the embedded ones. Their contents can't change while the
+// server runs, so this is done once rather than on every request.
+func contentETags(fsys fs.FS) map[string]string {
+	etags := make(map[string]string)
+	fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || !info.ModTime().IsZero() {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil
+		}
+		sum := sha256.Sum256(data)
+		etags[name] = fmt.Sprintf(`"%x"`, sum[:8])
+		return nil
+	})
+	return etags
+}
+
+// staticETag derives an ETag from a file's size and modification time,
+// or for embedded files, which have none, looks up the hash of its
+// contents in etags.
+func staticETag(etags map[string]string, name string, info fs.FileInfo) (string, bool) {
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()), true
+	}
+	etag, ok := etags[name]
+	return etag, ok
+}
@@ -0,0 +1,132 @@
+package srv
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStaticCaching(t *testing.T) {
+	get := func(handler http.Handler, path, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("production", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON, WithStaticMaxAge(86400), WithStaticDir("static"))
+		handler := server.routes()
+
+		w := get(handler, "/static/style.css", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+		if got := w.Header().Get("Cache-Control"); got != "public, max-age=86400" {
+			t.Errorf("expected Cache-Control public, max-age=86400, got %q", got)
+		}
+		if w.Header().Get("Last-Modified") == "" {
+			t.Error("expected Last-Modified header")
+		}
+		etag := w.Header().Get("ETag")
+		if etag == "" {
+			t.Fatal("expected ETag header")
+		}
+
+		w = get(handler, "/static/style.css", etag)
+		if w.Code != http.StatusNotModified {
+			t.Errorf("expected status 304 for a matching ETag, got %d", w.Code)
+		}
+
+		w = get(handler, "/static/missing.css", "")
+		if w.Code != http.StatusNotFound || w.Header().Get("Cache-Control") != "" {
+			t.Errorf("expected an uncached 404, got %d with Cache-Control %q", w.Code, w.Header().Get("Cache-Control"))
+		}
+	})
+
+	t.Run("embedded", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON)
+		handler := server.routes()
+
+		w := get(handler, "/static/style.css", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+		if got := w.Header().Get("Cache-Control"); got != "public, max-age=3600" {
+			t.Errorf("expected the default Cache-Control public, max-age=3600, got %q", got)
+		}
+		etag := w.Header().Get("ETag")
+		if etag == "" {
+			t.Fatal("expected an ETag for an embedded file")
+		}
+		if want := contentETags(server.staticFS)["style.css"]; etag != want {
+			t.Errorf("expected the content hash computed up front, %s, got %s", want, etag)
+		}
+		if w = get(handler, "/static/style.css", etag); w.Code != http.StatusNotModified {
+			t.Errorf("expected status 304 for a matching ETag, got %d", w.Code)
+		}
+		if other := get(handler, "/static/script.js", "").Header().Get("ETag"); other == etag {
+			t.Errorf("expected different files to get different ETags, both got %s", etag)
+		}
+	})
+
+	t.Run("dev mode", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON, WithDevMode(true))
+		w := get(server.routes(), "/static/style.css", "")
+		if got := w.Header().Get("Cache-Control"); got != "no-cache" {
+			t.Errorf("expected Cache-Control no-cache in dev mode, got %q", got)
+		}
+	})
+}
+
+func TestRootStaticFiles(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	handler := server.routes()
+	for _, test := range []struct {
+		path, contentType string
+	}{
+		{"/favicon.ico", "image/x-icon"},
+		{"/site.webmanifest", "application/manifest+json"},
+		{"/static/icon-192.png", "image/png"},
+		{"/static/icon-512.png", "image/png"},
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", test.path, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != test.contentType {
+			t.Errorf("%s: expected Content-Type %q, got %q", test.path, test.contentType, got)
+		}
+		if got := w.Header().Get("Cache-Control"); got != "public, max-age=3600" {
+			t.Errorf("%s: expected the static Cache-Control, got %q", test.path, got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/site.webmanifest", nil))
+	var manifest struct {
+		StartURL string `json:"start_url"`
+		Icons    []struct {
+			Src string `json:"src"`
+		} `json:"icons"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &manifest); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	if manifest.StartURL != "/" || len(manifest.Icons) == 0 {
+		t.Errorf("expected a start URL and icons, got %+v", manifest)
+	}
+	for _, icon := range manifest.Icons {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, icon.Src, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("manifest icon %s: expected status 200, got %d", icon.Src, w.Code)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package srv
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"srv.exe.dev/db"
+	"srv.exe.dev/db/dbgen"
+)
+
+// Store is the persistence the server needs: saved locations and recorded
+// observations. The sqlite implementation is opened by NewWithConfig;
+// tests can swap in another with WithStore.
+type Store interface {
+	InsertObservation(ctx context.Context, arg dbgen.InsertObservationParams) error
+	// ListObservations returns observations recorded at or after
+	// arg.RecordedAt, newest first, at most arg.Limit of them.
+	ListObservations(ctx context.Context, arg dbgen.ListObservationsParams) ([]dbgen.Observation, error)
+	// AddLocation returns sql.ErrNoRows if the name is taken.
+	AddLocation(ctx context.Context, arg dbgen.AddLocationParams) (dbgen.Location, error)
+	// ListLocations returns the saved locations ordered by name.
+	ListLocations(ctx context.Context) ([]dbgen.Location, error)
+	// LocationWithName returns sql.ErrNoRows for an unknown name.
+	LocationWithName(ctx context.Context, name string) (dbgen.Location, error)
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// WithStore sets the server's store, in place of the sqlite database at
+// DBPath.
+func WithStore(st Store) Option {
+	return func(s *Server) {
+		s.Store = st
+	}
+}
+
+// sqlStore is a Store backed by the sqlc queries over a sqlite database.
+type sqlStore struct {
+	*dbgen.Queries
+	db *sql.DB
+}
+
+// openSQLStore opens the sqlite database at path and runs its migrations.
+func openSQLStore(path string) (*sqlStore, error) {
+	wdb, err := db.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open db: %w", err)
+	}
+	if err := db.RunMigrations(wdb); err != nil {
+		wdb.Close()
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+	return &sqlStore{Queries: dbgen.New(wdb), db: wdb}, nil
+}
+
+func (st *sqlStore) Ping(ctx context.Context) error {
+	return st.db.PingContext(ctx)
+}
+
+func (st *sqlStore) Close() error {
+	return st.db.Close()
+}
@@ -0,0 +1,278 @@
+package srv
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"srv.exe.dev/db"
+	"srv.exe.dev/db/dbgen"
+)
+
+// memStore is an in-memory Store for handler tests. Setting err makes
+// every call fail with it.
+type memStore struct {
+	mu           sync.Mutex
+	observations []dbgen.Observation
+	locations    map[string]dbgen.Location
+	err          error
+}
+
+func newMemStore() *memStore {
+	return &memStore{locations: make(map[string]dbgen.Location)}
+}
+
+func (m *memStore) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+func (m *memStore) InsertObservation(ctx context.Context, arg dbgen.InsertObservationParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.observations = append(m.observations, dbgen.Observation{
+		ID:            int64(len(m.observations) + 1),
+		RecordedAt:    arg.RecordedAt,
+		Latitude:      arg.Latitude,
+		Longitude:     arg.Longitude,
+		Units:         arg.Units,
+		Temperature:   arg.Temperature,
+		FeelsLike:     arg.FeelsLike,
+		Humidity:      arg.Humidity,
+		WindSpeed:     arg.WindSpeed,
+		WindDirection: arg.WindDirection,
+		WeatherCode:   arg.WeatherCode,
+		Precipitation: arg.Precipitation,
+		CloudCover:    arg.CloudCover,
+		IsDay:         arg.IsDay,
+	})
+	return nil
+}
+
+func (m *memStore) ListObservations(ctx context.Context, arg dbgen.ListObservationsParams) ([]dbgen.Observation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	observations := []dbgen.Observation{}
+	for _, o := range m.observations {
+		if !o.RecordedAt.Before(arg.RecordedAt) {
+			observations = append(observations, o)
+		}
+	}
+	slices.SortStableFunc(observations, func(a, b dbgen.Observation) int {
+		return b.RecordedAt.Compare(a.RecordedAt)
+	})
+	return observations[:min(int64(len(observations)), max(arg.Limit, 0))], nil
+}
+
+func (m *memStore) AddLocation(ctx context.Context, arg dbgen.AddLocationParams) (dbgen.Location, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return dbgen.Location{}, m.err
+	}
+	if _, ok := m.locations[arg.Name]; ok {
+		return dbgen.Location{}, sql.ErrNoRows
+	}
+	loc := dbgen.Location{Name: arg.Name, Latitude: arg.Latitude, Longitude: arg.Longitude, CreatedAt: arg.CreatedAt}
+	m.locations[arg.Name] = loc
+	return loc, nil
+}
+
+func (m *memStore) ListLocations(ctx context.Context) ([]dbgen.Location, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	locations := []dbgen.Location{}
+	for _, loc := range m.locations {
+		locations = append(locations, loc)
+	}
+	slices.SortFunc(locations, func(a, b dbgen.Location) int { return cmp.Compare(a.Name, b.Name) })
+	return locations, nil
+}
+
+func (m *memStore) LocationWithName(ctx context.Context, name string) (dbgen.Location, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return dbgen.Location{}, m.err
+	}
+	loc, ok := m.locations[name]
+	if !ok {
+		return dbgen.Location{}, sql.ErrNoRows
+	}
+	return loc, nil
+}
+
+func (m *memStore) Ping(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+func (m *memStore) Close() error {
+	m.fail(errors.New("store closed"))
+	return nil
+}
+
+// TestStores runs the same checks against the sqlite store and the fake,
+// so handler tests using the fake see the behavior they would in
+// production.
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"sqlite": func(t *testing.T) Store {
+			st, err := openSQLStore(filepath.Join(t.TempDir(), "db.sqlite3"))
+			if err != nil {
+				t.Fatalf("open store: %v", err)
+			}
+			t.Cleanup(func() { st.Close() })
+			return st
+		},
+		"memory": func(t *testing.T) Store { return newMemStore() },
+	}
+	ctx := context.Background()
+	base := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			t.Run("observations", func(t *testing.T) {
+				st := open(t)
+				observations, err := st.ListObservations(ctx, dbgen.ListObservationsParams{Limit: 10})
+				if err != nil || len(observations) != 0 {
+					t.Fatalf("expected no observations, got %v, %v", observations, err)
+				}
+				for i := range 4 {
+					err := st.InsertObservation(ctx, dbgen.InsertObservationParams{
+						RecordedAt:  base.Add(time.Duration(i) * time.Hour),
+						Units:       string(Imperial),
+						Temperature: float64(40 + i),
+					})
+					if err != nil {
+						t.Fatalf("insert observation: %v", err)
+					}
+				}
+				observations, err = st.ListObservations(ctx, dbgen.ListObservationsParams{RecordedAt: base.Add(time.Hour), Limit: 2})
+				if err != nil {
+					t.Fatalf("list observations: %v", err)
+				}
+				var temps []float64
+				for _, o := range observations {
+					temps = append(temps, o.Temperature)
+				}
+				if !slices.Equal(temps, []float64{43, 42}) {
+					t.Errorf("expected the newest two since the bound, got %v", temps)
+				}
+			})
+
+			t.Run("locations", func(t *testing.T) {
+				st := open(t)
+				for _, name := range []string{"Paris", "Oslo"} {
+					if _, err := st.AddLocation(ctx, dbgen.AddLocationParams{Name: name, CreatedAt: base}); err != nil {
+						t.Fatalf("add %s: %v", name, err)
+					}
+				}
+				if _, err := st.AddLocation(ctx, dbgen.AddLocationParams{Name: "Paris", CreatedAt: base}); !errors.Is(err, sql.ErrNoRows) {
+					t.Errorf("expected sql.ErrNoRows for a duplicate, got %v", err)
+				}
+				locations, err := st.ListLocations(ctx)
+				if err != nil || len(locations) != 2 || locations[0].Name != "Oslo" {
+					t.Errorf("expected Oslo then Paris, got %v, %v", locations, err)
+				}
+				if loc, err := st.LocationWithName(ctx, "Paris"); err != nil || loc.Name != "Paris" {
+					t.Errorf("LocationWithName(Paris) = %v, %v", loc, err)
+				}
+				if _, err := st.LocationWithName(ctx, "Atlantis"); !errors.Is(err, sql.ErrNoRows) {
+					t.Errorf("expected sql.ErrNoRows for an unknown name, got %v", err)
+				}
+			})
+
+			t.Run("closed", func(t *testing.T) {
+				st := open(t)
+				if err := st.Ping(ctx); err != nil {
+					t.Fatalf("ping: %v", err)
+				}
+				st.Close()
+				if err := st.Ping(ctx); err == nil {
+					t.Error("expected ping to fail after close")
+				}
+			})
+		})
+	}
+}
+
+func TestMigrationsOnFreshDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fresh.sqlite3")
+	// Twice, to check migrations already applied are skipped.
+	for range 2 {
+		server, err := New(path, "test-hostname")
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		server.Store.Close()
+	}
+
+	sqldb, err := db.Open(path)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer sqldb.Close()
+
+	rows, err := sqldb.Query("SELECT type, name FROM sqlite_master WHERE name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		t.Fatalf("query sqlite_master: %v", err)
+	}
+	defer rows.Close()
+	schema := make(map[string]string)
+	for rows.Next() {
+		var typ, name string
+		if err := rows.Scan(&typ, &name); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		schema[name] = typ
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("read sqlite_master: %v", err)
+	}
+	for name, typ := range map[string]string{
+		"migrations":               "table",
+		"visitors":                 "table",
+		"observations":             "table",
+		"observations_recorded_at": "index",
+		"locations":                "table",
+	} {
+		if schema[name] != typ {
+			t.Errorf("expected %s %s, got %q", typ, name, schema[name])
+		}
+	}
+
+	var applied []int
+	migrations, err := sqldb.Query("SELECT migration_number FROM migrations ORDER BY migration_number")
+	if err != nil {
+		t.Fatalf("query migrations: %v", err)
+	}
+	defer migrations.Close()
+	for migrations.Next() {
+		var n int
+		if err := migrations.Scan(&n); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		applied = append(applied, n)
+	}
+	if !slices.Equal(applied, []int{1, 2, 3}) {
+		t.Errorf("expected migrations 1, 2 and 3 recorded once each, got %v", applied)
+	}
+}
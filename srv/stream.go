@@ -0,0 +1,138 @@
+package srv
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// streamKeepAlive is how often an idle stream sends a comment so proxies
+// don't time the connection out.
+const streamKeepAlive = 30 * time.Second
+
+// forecastUpdate is a freshly fetched forecast and the query it is for.
+type forecastUpdate struct {
+	query    forecastQuery
+	forecast *Forecast
+}
+
+// updateBroadcaster fans out freshly fetched forecasts to stream
+// subscribers. It is safe for concurrent use.
+type updateBroadcaster struct {
+	mu       sync.Mutex
+	subs     map[chan forecastUpdate]struct{}
+	closed   chan struct{}
+	initOnce sync.Once
+	stopOnce sync.Once
+}
+
+// done returns a channel that is closed when the broadcaster shuts down.
+func (b *updateBroadcaster) done() chan struct{} {
+	b.initOnce.Do(func() { b.closed = make(chan struct{}) })
+	return b.closed
+}
+
+// close tells every stream to end, so open connections don't hold up a
+// graceful shutdown.
+func (b *updateBroadcaster) close() {
+	done := b.done()
+	b.stopOnce.Do(func() { close(done) })
+}
+
+// subscribe registers a channel that receives every published update. The
+// returned function unregisters it.
+func (b *updateBroadcaster) subscribe() (<-chan forecastUpdate, func()) {
+	ch := make(chan forecastUpdate, 1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs == nil {
+		b.subs = make(map[chan forecastUpdate]struct{})
+	}
+	b.subs[ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, ch)
+	}
+}
+
+// publish sends u to every subscriber without blocking. A subscriber that
+// hasn't read the previous update has it replaced, so slow clients skip to
+// the latest forecast rather than stalling the refresher.
+func (b *updateBroadcaster) publish(u forecastUpdate) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- u:
+		default:
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- u
+		}
+	}
+}
+
+// HandleStream streams the current conditions for the requested location
+// and units as Server-Sent Events: once on connect, then every time a
+// fresh forecast for that query is fetched.
+func (s *Server) HandleStream(w http.ResponseWriter, r *http.Request) {
+	// Subscribe before the initial fetch so no update can slip in between.
+	updates, unsubscribe := s.updates.subscribe()
+	defer unsubscribe()
+	query, forecast, _, ok := s.forecastForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	rc := http.NewResponseController(w)
+	lang := requestLanguage(r)
+
+	send := func(current *WeatherData) bool {
+		data, err := json.Marshal(currentResponse{localizeCurrent(current, lang), openMeteoSource})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "encode stream event", "error", err)
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "event: weather\ndata: %s\n\n", data); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	if !send(forecast.Current) {
+		return
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.updates.done():
+			return
+		case u := <-updates:
+			// The initial fetch may itself have published the forecast
+			// that was just sent.
+			if u.query != query || u.forecast == forecast {
+				continue
+			}
+			forecast = u.forecast
+			if !send(forecast.Current) {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
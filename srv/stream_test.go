@@ -0,0 +1,77 @@
+package srv
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleStream(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	ts := httptest.NewServer(server.routes())
+	t.Cleanup(ts.Close)
+
+	resp, err := http.Get(ts.URL + "/api/weather/stream")
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected Content-Type text/event-stream, got %q", ct)
+	}
+
+	events := make(chan string)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				events <- data
+			}
+		}
+	}()
+	next := func(t *testing.T) (string, bool) {
+		t.Helper()
+		select {
+		case data, ok := <-events:
+			return data, ok
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+			return "", false
+		}
+	}
+
+	data, _ := next(t)
+	var current WeatherData
+	if err := json.Unmarshal([]byte(data), &current); err != nil {
+		t.Fatalf("decode event %q: %v", data, err)
+	}
+	if current.Temperature != 41.3 {
+		t.Errorf("expected temperature 41.3, got %v", current.Temperature)
+	}
+
+	// An update for another query is not sent; one for ours is.
+	if _, err := server.refreshForecast(context.Background(), server.defaultQuery(Metric)); err != nil {
+		t.Fatalf("refresh metric: %v", err)
+	}
+	if _, err := server.refreshForecast(context.Background(), server.defaultQuery(Imperial)); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	data, _ = next(t)
+	if err := json.Unmarshal([]byte(data), &current); err != nil {
+		t.Fatalf("decode event %q: %v", data, err)
+	}
+	if current.Units.Temperature != "°F" {
+		t.Errorf("expected an imperial update, got units %+v", current.Units)
+	}
+
+	server.updates.close()
+	if _, ok := next(t); ok {
+		t.Error("expected the stream to end on shutdown")
+	}
+}
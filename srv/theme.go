@@ -0,0 +1,60 @@
+package srv
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// themeCookie remembers the page theme chosen with ?theme=.
+const (
+	themeCookie       = "theme"
+	themeCookieMaxAge = 365 * 24 * time.Hour
+)
+
+// Page themes. Auto follows the browser's prefers-color-scheme.
+const (
+	themeLight = "light"
+	themeDark  = "dark"
+	themeAuto  = "auto"
+)
+
+// parseTheme interprets a theme name, defaulting to auto.
+func parseTheme(v string) (string, error) {
+	switch v {
+	case "":
+		return themeAuto, nil
+	case themeLight, themeDark, themeAuto:
+		return v, nil
+	default:
+		return "", fmt.Errorf("unknown theme %q", v)
+	}
+}
+
+// themeForRequest returns the theme r asks for. A ?theme= parameter wins
+// and is saved in the theme cookie for later visits; otherwise the cookie
+// is used, and a missing or unrecognized one means auto.
+func themeForRequest(w http.ResponseWriter, r *http.Request) (string, error) {
+	if r.URL.Query().Has("theme") {
+		theme, err := parseTheme(r.URL.Query().Get("theme"))
+		if err != nil {
+			return "", err
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     themeCookie,
+			Value:    theme,
+			Path:     "/",
+			MaxAge:   int(themeCookieMaxAge.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return theme, nil
+	}
+	if c, err := r.Cookie(themeCookie); err == nil {
+		if theme, err := parseTheme(c.Value); err == nil {
+			return theme, nil
+		}
+	}
+	return themeAuto, nil
+}
@@ -0,0 +1,98 @@
+package srv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestThemeForRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		cookie     string
+		want       string
+		wantCookie string
+	}{
+		{"default", "", "", themeAuto, ""},
+		{"from cookie", "", "dark", themeDark, ""},
+		{"bad cookie", "", "purple", themeAuto, ""},
+		{"param sets cookie", "?theme=light", "", themeLight, "light"},
+		{"param beats cookie", "?theme=auto", "dark", themeAuto, "auto"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/"+test.query, nil)
+			if test.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: themeCookie, Value: test.cookie})
+			}
+			w := httptest.NewRecorder()
+			theme, err := themeForRequest(w, r)
+			if err != nil {
+				t.Fatalf("themeForRequest: %v", err)
+			}
+			if theme != test.want {
+				t.Errorf("expected theme %q, got %q", test.want, theme)
+			}
+			cookies := w.Result().Cookies()
+			if test.wantCookie == "" {
+				if len(cookies) != 0 {
+					t.Errorf("expected no cookie, got %v", cookies)
+				}
+				return
+			}
+			if len(cookies) != 1 || cookies[0].Name != themeCookie || cookies[0].Value != test.wantCookie {
+				t.Fatalf("expected a %s=%s cookie, got %v", themeCookie, test.wantCookie, cookies)
+			}
+			if c := cookies[0]; c.Path != "/" || c.MaxAge <= 0 || c.SameSite != http.SameSiteLaxMode {
+				t.Errorf("expected a persistent site-wide cookie, got %+v", c)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	if _, err := themeForRequest(w, httptest.NewRequest(http.MethodGet, "/?theme=purple", nil)); err == nil {
+		t.Error("expected an error for an unknown theme")
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("expected no cookie for an unknown theme, got %v", cookies)
+	}
+}
+
+func TestHandleRootTheme(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+
+	get := func(t *testing.T, target, cookie string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: themeCookie, Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		server.HandleRoot(w, r)
+		return w
+	}
+
+	if body := get(t, "/", "").Body.String(); !strings.Contains(body, `class="theme-auto"`) {
+		t.Errorf("expected the auto theme by default, got %s", body)
+	}
+	if body := get(t, "/", "dark").Body.String(); !strings.Contains(body, `class="theme-dark"`) {
+		t.Errorf("expected the dark theme from the cookie, got %s", body)
+	}
+
+	w := get(t, "/?theme=light", "")
+	if !strings.Contains(w.Body.String(), `class="theme-light"`) {
+		t.Errorf("expected the light theme, got %s", w.Body.String())
+	}
+	if !strings.Contains(w.Header().Get("Set-Cookie"), "theme=light") {
+		t.Errorf("expected the theme cookie to be set, got %q", w.Header().Get("Set-Cookie"))
+	}
+	if !strings.Contains(w.Body.String(), "units=imperial&amp;theme=dark") {
+		t.Errorf("expected theme links that keep the units, got %s", w.Body.String())
+	}
+
+	if w := get(t, "/?theme=purple", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an unknown theme, got %d", w.Code)
+	}
+}
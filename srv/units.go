@@ -0,0 +1,164 @@
+package srv
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// UnitSystem selects the measurement units requested from Open-Meteo.
+type UnitSystem string
+
+const (
+	Imperial UnitSystem = "imperial"
+	Metric   UnitSystem = "metric"
+)
+
+// UnitLabels are the display suffixes for the values in a WeatherData.
+type UnitLabels struct {
+	Temperature   string
+	WindSpeed     string
+	Precipitation string
+	Pressure      string
+	Visibility    string
+	Snow          string
+}
+
+// system returns the unit system the labels belong to.
+func (l UnitLabels) system() UnitSystem {
+	if l == Metric.labels() {
+		return Metric
+	}
+	return Imperial
+}
+
+// parseUnitSystem interprets the ?units= query parameter, defaulting to imperial.
+func parseUnitSystem(v string) (UnitSystem, error) {
+	switch UnitSystem(v) {
+	case "", Imperial:
+		return Imperial, nil
+	case Metric:
+		return Metric, nil
+	default:
+		return "", fmt.Errorf("unknown unit system %q", v)
+	}
+}
+
+// setQueryParams sets the Open-Meteo unit parameters for u on q.
+func (u UnitSystem) setQueryParams(q url.Values) {
+	if u == Metric {
+		q.Set("temperature_unit", "celsius")
+		q.Set("wind_speed_unit", "kmh")
+		q.Set("precipitation_unit", "mm")
+		return
+	}
+	q.Set("temperature_unit", "fahrenheit")
+	q.Set("wind_speed_unit", "mph")
+	q.Set("precipitation_unit", "inch")
+}
+
+func (u UnitSystem) labels() UnitLabels {
+	if u == Metric {
+		return UnitLabels{Temperature: "°C", WindSpeed: "km/h", Precipitation: "mm", Pressure: "hPa", Visibility: "km", Snow: "cm"}
+	}
+	return UnitLabels{Temperature: "°F", WindSpeed: "mph", Precipitation: "in", Pressure: "inHg", Visibility: "mi", Snow: "in"}
+}
+
+// formatPrecipitation formats an amount of precipitation in u's unit for
+// display: inches to 0.01 and millimeters to 0.1, with amounts too small
+// to show as "<0.01 in" or "<0.1 mm", and "—" for none.
+func formatPrecipitation(amount float64, u UnitSystem) string {
+	precision, smallest := 2, 0.01
+	if u == Metric {
+		precision, smallest = 1, 0.1
+	}
+	suffix := " " + u.labels().Precipitation
+	switch {
+	case amount <= 0:
+		return "—"
+	case amount < smallest/2:
+		return "<" + strconv.FormatFloat(smallest, 'f', precision, 64) + suffix
+	}
+	return strconv.FormatFloat(amount, 'f', precision, 64) + suffix
+}
+
+// hPaPerInHg is the number of hectopascals in one inch of mercury.
+const hPaPerInHg = 33.8639
+
+// pressure converts a pressure in hPa, which Open-Meteo always reports,
+// into the unit system's pressure unit.
+func (u UnitSystem) pressure(hPa float64) float64 {
+	if u == Metric {
+		return hPa
+	}
+	return hPaToInHg(hPa)
+}
+
+// Lengths in meters
+const (
+	metersPerMile = 1609.344
+	metersPerFoot = 0.3048
+	metersPerInch = 0.0254
+)
+
+// kmPerMile is the number of kilometers in one statute mile.
+const kmPerMile = metersPerMile / 1000
+
+// Conversions between imperial and metric units, and from the meters
+// Open-Meteo reports lengths in. They are unrounded, so callers round
+// once, for display.
+func fToC(f float64) float64          { return (f - 32) * 5 / 9 }
+func cToF(c float64) float64          { return c*9/5 + 32 }
+func kmhToMph(kmh float64) float64    { return kmh / kmPerMile }
+func hPaToInHg(hPa float64) float64   { return hPa / hPaPerInHg }
+func ftToMeters(ft float64) float64   { return ft * metersPerFoot }
+func metersToKm(m float64) float64    { return m / 1000 }
+func metersToMiles(m float64) float64 { return m / metersPerMile }
+func metersToCm(m float64) float64    { return m * 100 }
+func metersToIn(m float64) float64    { return m / metersPerInch }
+
+// mph converts a wind speed in the unit system's wind unit into mph.
+func (u UnitSystem) mph(speed float64) float64 {
+	if u == Metric {
+		return kmhToMph(speed)
+	}
+	return speed
+}
+
+// fahrenheit converts a temperature in the unit system's unit into °F.
+func (u UnitSystem) fahrenheit(t float64) float64 {
+	if u == Metric {
+		return cToF(t)
+	}
+	return t
+}
+
+// fromFahrenheit converts a temperature in °F into the unit system's unit.
+func (u UnitSystem) fromFahrenheit(f float64) float64 {
+	if u == Metric {
+		return fToC(f)
+	}
+	return f
+}
+
+// visibility converts a distance in meters, which Open-Meteo always
+// reports for visibility, into miles or kilometers.
+func (u UnitSystem) visibility(meters float64) float64 {
+	if u == Metric {
+		return metersToKm(meters)
+	}
+	return metersToMiles(meters)
+}
+
+// snowDepth converts a snow depth Open-Meteo reported in unit, meters for
+// metric requests and feet for imperial ones, into centimeters or inches to
+// match the snowfall it reports alongside.
+func (u UnitSystem) snowDepth(depth float64, unit string) float64 {
+	if unit == "ft" {
+		depth = ftToMeters(depth)
+	}
+	if u == Metric {
+		return metersToCm(depth)
+	}
+	return metersToIn(depth)
+}
@@ -0,0 +1,215 @@
+package srv
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseUnitSystem(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    UnitSystem
+		wantErr bool
+	}{
+		{"", Imperial, false},
+		{"imperial", Imperial, false},
+		{"metric", Metric, false},
+		{"kelvin", "", true},
+	}
+	for _, test := range tests {
+		got, err := parseUnitSystem(test.input)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseUnitSystem(%q) error = %v, wantErr %v", test.input, err, test.wantErr)
+		}
+		if got != test.want {
+			t.Errorf("parseUnitSystem(%q) = %q, expected %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestMetricUnitsQuery(t *testing.T) {
+	var gotQuery string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+		w.Write([]byte(stubForecastJSON))
+	}))
+	defer upstream.Close()
+	server, _ := newStubServer(t, stubForecastJSON)
+	server.ForecastURL = upstream.URL
+
+	req := httptest.NewRequest(http.MethodGet, "/?units=metric", nil)
+	w := httptest.NewRecorder()
+	server.HandleRoot(w, req)
+
+	for _, param := range []string{"temperature_unit=celsius", "wind_speed_unit=kmh", "precipitation_unit=mm"} {
+		if !strings.Contains(gotQuery, param) {
+			t.Errorf("expected upstream query to contain %q, got %q", param, gotQuery)
+		}
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "41°C") {
+		t.Errorf("expected celsius label in page, got body: %s", body)
+	}
+	if !strings.Contains(body, "km/h") {
+		t.Errorf("expected km/h label in page, got body: %s", body)
+	}
+}
+
+func TestInvalidUnitsRejected(t *testing.T) {
+	server, hits := newStubServer(t, stubForecastJSON)
+
+	for _, handler := range []http.HandlerFunc{server.HandleRoot, server.HandleAPI} {
+		req := httptest.NewRequest(http.MethodGet, "/?units=kelvin", nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("expected no upstream requests, got %d", n)
+	}
+}
+
+func TestVisibilityUnits(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	for _, test := range []struct {
+		units      UnitSystem
+		visibility float64
+		label      string
+	}{
+		{Imperial, 10.0, "mi"},
+		{Metric, 16.09, "km"},
+	} {
+		forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(test.units))
+		if err != nil {
+			t.Fatalf("fetch %s: %v", test.units, err)
+		}
+		if math.Abs(forecast.Current.Visibility-test.visibility) > 0.01 {
+			t.Errorf("%s: expected visibility %.2f, got %.2f", test.units, test.visibility, forecast.Current.Visibility)
+		}
+		if forecast.Current.Units.Visibility != test.label {
+			t.Errorf("%s: expected visibility label %q, got %q", test.units, test.label, forecast.Current.Units.Visibility)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	server.HandleAPICurrent(w, httptest.NewRequest(http.MethodGet, "/api/weather/current", nil))
+	var current struct{ Visibility float64 }
+	if err := json.Unmarshal(w.Body.Bytes(), &current); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if math.Abs(current.Visibility-10) > 0.01 {
+		t.Errorf("expected visibility 10 in API output, got %v", current.Visibility)
+	}
+}
+
+func TestPressureUnits(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	for _, test := range []struct {
+		units    UnitSystem
+		pressure float64
+		label    string
+	}{
+		{Imperial, 30.00, "inHg"},
+		{Metric, 1016.0, "hPa"},
+	} {
+		forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(test.units))
+		if err != nil {
+			t.Fatalf("fetch %s: %v", test.units, err)
+		}
+		if math.Abs(forecast.Current.Pressure-test.pressure) > 0.01 {
+			t.Errorf("%s: expected pressure %.2f, got %.2f", test.units, test.pressure, forecast.Current.Pressure)
+		}
+		if forecast.Current.Units.Pressure != test.label {
+			t.Errorf("%s: expected pressure label %q, got %q", test.units, test.label, forecast.Current.Units.Pressure)
+		}
+		if forecast.Current.DewPoint != 29.1 {
+			t.Errorf("%s: expected dew point 29.1, got %v", test.units, forecast.Current.DewPoint)
+		}
+	}
+}
+
+func TestSnowDepthUnits(t *testing.T) {
+	for _, test := range []struct {
+		units UnitSystem
+		depth float64
+		unit  string
+		want  float64
+	}{
+		{Metric, 0.25, "m", 25},
+		{Metric, 1, "ft", 30.48},
+		{Imperial, 0.5, "ft", 6},
+		{Imperial, 0.0254, "m", 1},
+	} {
+		if got := test.units.snowDepth(test.depth, test.unit); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s snowDepth(%v, %q) = %v, want %v", test.units, test.depth, test.unit, got, test.want)
+		}
+	}
+}
+
+func TestFormatPrecipitation(t *testing.T) {
+	for _, test := range []struct {
+		units  UnitSystem
+		amount float64
+		want   string
+	}{
+		{Imperial, 0, "—"},
+		{Imperial, 0.001, "<0.01 in"},
+		{Imperial, 0.03, "0.03 in"},
+		{Imperial, 0.125, "0.12 in"},
+		{Imperial, 12.5, "12.50 in"},
+		{Metric, 0, "—"},
+		{Metric, 0.04, "<0.1 mm"},
+		{Metric, 0.8, "0.8 mm"},
+		{Metric, 254.06, "254.1 mm"},
+	} {
+		if got := formatPrecipitation(test.amount, test.units); got != test.want {
+			t.Errorf("formatPrecipitation(%v, %s) = %q, want %q", test.amount, test.units, got, test.want)
+		}
+	}
+
+	server, _ := newStubServer(t, stubForecastJSON)
+	body := renderRoot(t, server, "/?units=metric").Body.String()
+	if !strings.Contains(body, `<div class="detail-value">—</div>`) {
+		t.Errorf("expected no precipitation shown as a dash, got %s", body)
+	}
+}
+
+func TestConversions(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		convert  func(float64) float64
+		in, want float64
+	}{
+		{"fToC freezing", fToC, 32, 0},
+		{"fToC boiling", fToC, 212, 100},
+		{"fToC crossover", fToC, -40, -40},
+		{"cToF freezing", cToF, 0, 32},
+		{"cToF boiling", cToF, 100, 212},
+		{"cToF body", cToF, 37, 98.6},
+		{"kmhToMph", kmhToMph, 100, 62.137119},
+		{"hPaToInHg", hPaToInHg, 1013.25, 29.9213},
+		{"ftToMeters", ftToMeters, 10, 3.048},
+		{"metersToKm", metersToKm, 24140, 24.14},
+		{"metersToMiles", metersToMiles, 16093.44, 10},
+		{"metersToCm", metersToCm, 0.25, 25},
+		{"metersToIn", metersToIn, 0.127, 5},
+	} {
+		if got := test.convert(test.in); math.Abs(got-test.want) > 1e-4 {
+			t.Errorf("%s(%v) = %v, want %v", test.name, test.in, got, test.want)
+		}
+	}
+
+	// A round trip comes back to where it started, with no drift.
+	for _, v := range []float64{-40, 0, 41.3, 98.6} {
+		if got := fToC(cToF(v)); math.Abs(got-v) > 1e-9 {
+			t.Errorf("fToC(cToF(%v)) = %v", v, got)
+		}
+	}
+}
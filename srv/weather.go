@@ -0,0 +1,997 @@
+package srv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"srv.exe.dev/db/dbgen"
+)
+
+// Weather data from Open-Meteo API
+type WeatherData struct {
+	Temperature    float64
+	FeelsLike      float64
+	Humidity       int
+	WindSpeed      float64
+	WindGust       float64
+	WindDirection  int
+	WeatherCode    int
+	IsDay          bool
+	Precipitation  float64
+	CloudCover     int
+	LastUpdated    string
+	Condition      string
+	ConditionEmoji string
+	Units          UnitLabels
+	Sunrise        string
+	Sunset         string
+	UVIndex        float64
+	UVRisk         string
+	Pressure       float64
+	DewPoint       float64
+	Visibility     float64
+	Snowfall       float64
+	SnowDepth      float64
+	FeelsLikeDelta float64
+	FeelsLikeLabel string
+	PrecipProbNow  int
+	// WindChill and HeatIndex are the NWS indexes, set only when it is
+	// cold or hot enough for them to apply.
+	WindChill *float64
+	HeatIndex *float64
+	// ClothingAdvice suggests what to wear, from clothingAdvice.
+	ClothingAdvice string
+}
+
+// ShowSnow reports whether the page should show snowfall and snow depth:
+// when there is some, or when it is snowing but nothing has settled yet.
+func (w *WeatherData) ShowSnow() bool {
+	return w.Snowfall > 0 || w.SnowDepth > 0 || isSnowCode(w.WeatherCode)
+}
+
+// HourlyForecast represents one hour of forecast data
+type HourlyForecast struct {
+	Time           string
+	Hour           string
+	Temperature    float64
+	WeatherCode    int
+	ConditionEmoji string
+	PrecipProb     int
+	IsDay          bool
+}
+
+// DailyForecast represents one day of forecast data
+type DailyForecast struct {
+	Date           string
+	Day            string
+	High           float64
+	Low            float64
+	WeatherCode    int
+	Condition      string
+	ConditionEmoji string
+	PrecipProbMax  int
+}
+
+// forecastQuery identifies a forecast: where it is for and in which units.
+// It is comparable so it can key the cache.
+type forecastQuery struct {
+	Lat   float64
+	Lon   float64
+	Units UnitSystem
+	// Hours is how many hourly entries to fetch when more than the
+	// server's ForecastHours are needed, and 0 otherwise, so most requests
+	// share a cache entry.
+	Hours int
+}
+
+// newForecastQuery returns the query for lat, lon in units, with the
+// coordinates rounded to the 4 decimals sent upstream, so places within
+// about 10 m of each other share a cache entry.
+func newForecastQuery(lat, lon float64, units UnitSystem) forecastQuery {
+	round := func(v float64) float64 { return math.Round(v*1e4) / 1e4 }
+	return forecastQuery{Lat: round(lat), Lon: round(lon), Units: units}
+}
+
+// upstreamHours returns the forecastQuery.Hours for a forecast of at least
+// hours hourly entries. Longer forecasts are fetched in whole days, up to
+// maxForecastHours, to bound how many variants of a query the cache holds.
+func (s *Server) upstreamHours(hours int) int {
+	if hours <= s.ForecastHours {
+		return 0
+	}
+	return min((hours+23)/24*24, maxForecastHours)
+}
+
+// hourlyEntries returns how many hourly entries to fetch for q.
+func (s *Server) hourlyEntries(q forecastQuery) int {
+	return max(q.Hours, s.ForecastHours)
+}
+
+// Forecast is everything fetched from Open-Meteo for one location
+type Forecast struct {
+	Current *WeatherData
+	Hourly  []HourlyForecast
+	Daily   []DailyForecast
+	// PrecipTotal24h is the expected precipitation over the next 24
+	// hours, in the current precipitation unit.
+	PrecipTotal24h float64
+	// TodayHigh and TodayLow are the extremes of the next 24 hourly
+	// temperatures, or nil if there is no hourly data.
+	TodayHigh *float64
+	TodayLow  *float64
+	// AirQuality is nil if the air-quality fetch failed.
+	AirQuality *AirQuality
+	// raw is the Open-Meteo response the forecast was built from, served
+	// by /api/raw. It is nil when a fallback provider supplied the forecast.
+	raw *openMeteoResponse
+	// fetchedAt is when the forecast was fetched from upstream.
+	fetchedAt time.Time
+}
+
+// Open-Meteo API response structure
+type openMeteoResponse struct {
+	Current struct {
+		Time             string  `json:"time"`
+		Temperature2m    float64 `json:"temperature_2m"`
+		ApparentTemp     float64 `json:"apparent_temperature"`
+		RelativeHumidity int     `json:"relative_humidity_2m"`
+		WindSpeed10m     float64 `json:"wind_speed_10m"`
+		WindDirection10m int     `json:"wind_direction_10m"`
+		WindGusts10m     float64 `json:"wind_gusts_10m"`
+		WeatherCode      int     `json:"weather_code"`
+		IsDay            int     `json:"is_day"`
+		Precipitation    float64 `json:"precipitation"`
+		CloudCover       int     `json:"cloud_cover"`
+		UVIndex          float64 `json:"uv_index"`
+		SurfacePressure  float64 `json:"surface_pressure"`
+		DewPoint2m       float64 `json:"dew_point_2m"`
+		Visibility       float64 `json:"visibility"`
+		Snowfall         float64 `json:"snowfall"`
+		SnowDepth        float64 `json:"snow_depth"`
+	} `json:"current"`
+	CurrentUnits struct {
+		SnowDepth string `json:"snow_depth"`
+	} `json:"current_units"`
+	Hourly struct {
+		Time          []string  `json:"time"`
+		Temperature2m []float64 `json:"temperature_2m"`
+		WeatherCode   []int     `json:"weather_code"`
+		PrecipProb    []int     `json:"precipitation_probability"`
+		Precipitation []float64 `json:"precipitation"`
+		IsDay         []int     `json:"is_day"`
+	} `json:"hourly"`
+	Daily struct {
+		Time             []string  `json:"time"`
+		Temperature2mMax []float64 `json:"temperature_2m_max"`
+		Temperature2mMin []float64 `json:"temperature_2m_min"`
+		WeatherCode      []int     `json:"weather_code"`
+		PrecipProbMax    []int     `json:"precipitation_probability_max"`
+		Sunrise          []string  `json:"sunrise"`
+		Sunset           []string  `json:"sunset"`
+	} `json:"daily"`
+}
+
+// forecastDays is how many days of daily data Open-Meteo returns by default.
+const forecastDays = 7
+
+// reserve sizes the hourly and daily slices for the expected number of
+// entries, so decoding fills them without growing them repeatedly.
+func (r *openMeteoResponse) reserve(hours, days int) {
+	h, d := &r.Hourly, &r.Daily
+	h.Time = make([]string, 0, hours)
+	h.Temperature2m = make([]float64, 0, hours)
+	h.WeatherCode = make([]int, 0, hours)
+	h.PrecipProb = make([]int, 0, hours)
+	h.Precipitation = make([]float64, 0, hours)
+	h.IsDay = make([]int, 0, hours)
+	d.Time = make([]string, 0, days)
+	d.Temperature2mMax = make([]float64, 0, days)
+	d.Temperature2mMin = make([]float64, 0, days)
+	d.WeatherCode = make([]int, 0, days)
+	d.PrecipProbMax = make([]int, 0, days)
+	d.Sunrise = make([]string, 0, days)
+	d.Sunset = make([]string, 0, days)
+}
+
+// fetchWeather returns the current conditions and forecast, served from
+// the cache when the last fetch is younger than CacheTTL. While the
+// background refresher is running any cached entry is served, since it is
+// kept up to date independently of requests.
+func (s *Server) fetchWeather(ctx context.Context, q forecastQuery) (*Forecast, error) {
+	forecast, _, err := s.lookupForecast(ctx, q, false)
+	return forecast, err
+}
+
+// lookupForecast is fetchWeather, also reporting whether the forecast came
+// from the cache. With force it skips the cache and always refetches.
+func (s *Server) lookupForecast(ctx context.Context, q forecastQuery, force bool) (forecast *Forecast, cached bool, err error) {
+	if forecast, fetchedAt, ok := s.cache.get(q, s.Now()); ok && !force {
+		if s.refreshing.Load() || s.Now().Sub(fetchedAt) < s.CacheTTL {
+			return forecast, true, nil
+		}
+	}
+	forecast, err = s.refreshForecast(ctx, q)
+	return forecast, false, err
+}
+
+// refreshForecast fetches a fresh forecast from upstream and stores it in
+// the cache and the observation history. Concurrent refreshes of the same
+// query, say from the background refresher and a forced refresh, share
+// one upstream fetch and its result.
+func (s *Server) refreshForecast(ctx context.Context, q forecastQuery) (*Forecast, error) {
+	for {
+		v, err, _ := s.inflight.Do(fmt.Sprint(q), func() (any, error) {
+			return s.fetchForecast(ctx, q)
+		})
+		// The shared fetch runs under whichever caller started it. If that
+		// caller gave up, the rest of us still want a forecast, so try again.
+		if errors.Is(err, context.Canceled) && ctx.Err() == nil {
+			continue
+		}
+		forecast, _ := v.(*Forecast)
+		return forecast, err
+	}
+}
+
+// fetchForecast does the work of refreshForecast. Air quality is fetched
+// alongside the forecast, so a refresh takes as long as the slower of the
+// two rather than their sum; if air quality fails the forecast is still
+// returned, without it.
+func (s *Server) fetchForecast(ctx context.Context, q forecastQuery) (*Forecast, error) {
+	aqCtx, cancelAirQuality := context.WithCancel(ctx)
+	defer cancelAirQuality()
+	airQuality := make(chan *AirQuality, 1)
+	go func() {
+		aq, err := s.fetchAirQuality(aqCtx, q)
+		if err != nil && aqCtx.Err() == nil {
+			slog.WarnContext(ctx, "fetch air quality", "lat", q.Lat, "lon", q.Lon, "error", err)
+		}
+		airQuality <- aq
+	}()
+
+	forecast, err := s.fetchFromProviders(ctx, q)
+	// A fetch abandoned by its caller says nothing about upstream.
+	if ctx.Err() == nil {
+		s.fetchHealth.observe(s.Now(), err)
+	}
+	if err != nil {
+		// Air quality is useless without a forecast, so don't wait for it.
+		cancelAirQuality()
+		<-airQuality
+		return nil, err
+	}
+	forecast.AirQuality = <-airQuality
+	forecast.fetchedAt = s.Now()
+	s.cache.set(q, forecast, forecast.fetchedAt)
+	s.updates.publish(forecastUpdate{query: q, forecast: forecast})
+	s.recordObservation(ctx, q, forecast.Current)
+	return forecast, nil
+}
+
+// recordObservation stores a freshly fetched observation for history. A
+// failed write is logged rather than returned so the caller still gets
+// its weather.
+func (s *Server) recordObservation(ctx context.Context, q forecastQuery, w *WeatherData) {
+	err := s.Store.InsertObservation(ctx, dbgen.InsertObservationParams{
+		RecordedAt:    s.Now().UTC().Truncate(time.Second),
+		Latitude:      q.Lat,
+		Longitude:     q.Lon,
+		Units:         string(q.Units),
+		Temperature:   w.Temperature,
+		FeelsLike:     w.FeelsLike,
+		Humidity:      int64(w.Humidity),
+		WindSpeed:     w.WindSpeed,
+		WindDirection: int64(w.WindDirection),
+		WeatherCode:   int64(w.WeatherCode),
+		Precipitation: w.Precipitation,
+		CloudCover:    int64(w.CloudCover),
+		IsDay:         w.IsDay,
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "record observation", "error", err)
+	}
+}
+
+// DataSource credits where forecasts come from. Open-Meteo's data is
+// licensed under CC BY 4.0, which requires attribution.
+type DataSource struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	License string `json:"license"`
+}
+
+// openMeteoSource is the attribution shown on the page and in the API.
+var openMeteoSource = DataSource{
+	Name:    "Open-Meteo",
+	URL:     "https://open-meteo.com/",
+	License: "CC BY 4.0",
+}
+
+// setSourceLink credits openMeteoSource in a Link header, so responses
+// whose shape has no room for a source field, like the hourly array and
+// CSV, are attributed too.
+func setSourceLink(w http.ResponseWriter) {
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="via"; title="%s (%s)"`, openMeteoSource.URL, openMeteoSource.Name, openMeteoSource.License))
+}
+
+// UpstreamError is returned when Open-Meteo answers with a non-200 status.
+type UpstreamError struct {
+	StatusCode int
+	// Body is the start of the response body, for diagnostics.
+	Body string
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("weather API returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("weather API returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// upstreamErrorSnippet bounds how much of an error body UpstreamError keeps.
+const upstreamErrorSnippet = 512
+
+func newUpstreamError(resp *http.Response) *UpstreamError {
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, upstreamErrorSnippet))
+	return &UpstreamError{
+		StatusCode: resp.StatusCode,
+		Body:       strings.TrimSpace(string(snippet)),
+	}
+}
+
+// maxUpstreamBody bounds how much of an Open-Meteo response is read, so
+// a misbehaving upstream streaming a huge payload can't exhaust memory.
+// The longest forecast we request is a small fraction of it.
+const maxUpstreamBody = 1 << 20
+
+// errUpstreamTooLarge is returned when a response exceeds maxUpstreamBody.
+var errUpstreamTooLarge = fmt.Errorf("upstream response larger than %d bytes", maxUpstreamBody)
+
+// decodeUpstream decodes the JSON response body r into v, failing with
+// errUpstreamTooLarge rather than reading past maxUpstreamBody.
+func decodeUpstream(r io.Reader, v any) error {
+	lr := &io.LimitedReader{R: r, N: maxUpstreamBody + 1}
+	err := json.NewDecoder(lr).Decode(v)
+	if lr.N <= 0 {
+		return errUpstreamTooLarge
+	}
+	return err
+}
+
+// Variables requested from the Open-Meteo forecast API
+var (
+	currentVariables = []string{
+		"temperature_2m", "relative_humidity_2m", "apparent_temperature", "precipitation",
+		"weather_code", "cloud_cover", "wind_speed_10m", "wind_direction_10m", "wind_gusts_10m", "is_day",
+		"uv_index", "surface_pressure", "dew_point_2m", "visibility", "snowfall", "snow_depth",
+	}
+	hourlyVariables = []string{
+		"temperature_2m", "weather_code", "precipitation_probability", "precipitation", "is_day",
+	}
+	dailyVariables = []string{
+		"temperature_2m_max", "temperature_2m_min", "weather_code", "precipitation_probability_max",
+		"sunrise", "sunset",
+	}
+
+	// Joined once rather than on every request.
+	currentParam = strings.Join(currentVariables, ",")
+	hourlyParam  = strings.Join(hourlyVariables, ",")
+	dailyParam   = strings.Join(dailyVariables, ",")
+)
+
+// forecastRequestURL builds the Open-Meteo forecast URL for q.
+func (s *Server) forecastRequestURL(q forecastQuery) string {
+	params := url.Values{}
+	params.Set("latitude", strconv.FormatFloat(q.Lat, 'f', 4, 64))
+	params.Set("longitude", strconv.FormatFloat(q.Lon, 'f', 4, 64))
+	params.Set("current", currentParam)
+	params.Set("hourly", hourlyParam)
+	params.Set("daily", dailyParam)
+	params.Set("timezone", s.Timezone)
+	params.Set("forecast_hours", strconv.Itoa(s.hourlyEntries(q)))
+	q.Units.setQueryParams(params)
+	return s.ForecastURL + "?" + params.Encode()
+}
+
+func (s *Server) fetchOpenMeteo(ctx context.Context, q forecastQuery) (*Forecast, error) {
+	units := q.Units
+	resp, err := s.getWithRetry(ctx, s.forecastRequestURL(q))
+	if err != nil {
+		return nil, fmt.Errorf("fetch weather: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newUpstreamError(resp)
+	}
+
+	var data openMeteoResponse
+	data.reserve(s.hourlyEntries(q), forecastDays)
+	if err := decodeUpstream(resp.Body, &data); err != nil {
+		return nil, fmt.Errorf("decode weather: %w", err)
+	}
+
+	condition, emoji := weatherCodeToCondition(data.Current.WeatherCode, data.Current.IsDay == 1)
+	// Rounded so the API doesn't report float noise like -5.699999999999996.
+	feelsLikeDelta := math.Round((data.Current.ApparentTemp-data.Current.Temperature2m)*10) / 10
+
+	weather := &WeatherData{
+		Temperature:    data.Current.Temperature2m,
+		FeelsLike:      data.Current.ApparentTemp,
+		Humidity:       data.Current.RelativeHumidity,
+		WindSpeed:      data.Current.WindSpeed10m,
+		WindGust:       data.Current.WindGusts10m,
+		WindDirection:  data.Current.WindDirection10m,
+		WeatherCode:    data.Current.WeatherCode,
+		IsDay:          data.Current.IsDay == 1,
+		Precipitation:  data.Current.Precipitation,
+		CloudCover:     data.Current.CloudCover,
+		UVIndex:        data.Current.UVIndex,
+		UVRisk:         uvRiskLabel(data.Current.UVIndex),
+		FeelsLikeDelta: feelsLikeDelta,
+		FeelsLikeLabel: feelsLikeLabel(feelsLikeDelta),
+		Pressure:       units.pressure(data.Current.SurfacePressure),
+		DewPoint:       data.Current.DewPoint2m,
+		Visibility:     units.visibility(data.Current.Visibility),
+		Snowfall:       data.Current.Snowfall,
+		SnowDepth:      units.snowDepth(data.Current.SnowDepth, data.CurrentUnits.SnowDepth),
+		LastUpdated:    data.Current.Time,
+		Condition:      condition,
+		ConditionEmoji: emoji,
+		Units:          units.labels(),
+	}
+	weather.WindChill, weather.HeatIndex = comfortIndexes(units, weather.Temperature, weather.WindSpeed, weather.Humidity)
+
+	if len(data.Daily.Sunrise) > 0 {
+		weather.Sunrise = s.formatClock(data.Daily.Sunrise[0])
+	}
+	if len(data.Daily.Sunset) > 0 {
+		weather.Sunset = s.formatClock(data.Daily.Sunset[0])
+	}
+
+	// Build hourly forecast. Only the time is essential: a field whose
+	// array is shorter than the times leaves its zero value in the later
+	// entries rather than truncating the forecast.
+	hourly := make([]HourlyForecast, 0, len(data.Hourly.Time))
+	for i, timeStr := range data.Hourly.Time {
+		if timeStr == "" {
+			continue
+		}
+		isDay := valueAt(data.Hourly.IsDay, i) == 1
+		code := valueAt(data.Hourly.WeatherCode, i)
+		_, hourEmoji := weatherCodeToCondition(code, isDay)
+
+		hourly = append(hourly, HourlyForecast{
+			Time:           timeStr,
+			Hour:           s.formatHour(timeStr),
+			Temperature:    valueAt(data.Hourly.Temperature2m, i),
+			WeatherCode:    code,
+			ConditionEmoji: hourEmoji,
+			PrecipProb:     valueAt(data.Hourly.PrecipProb, i),
+			IsDay:          isDay,
+		})
+	}
+
+	// Build daily forecast
+	daily := make([]DailyForecast, 0, len(data.Daily.Time))
+	for i, dateStr := range data.Daily.Time {
+		if i >= len(data.Daily.Temperature2mMax) || i >= len(data.Daily.Temperature2mMin) || i >= len(data.Daily.WeatherCode) {
+			break
+		}
+		dayCondition, dayEmoji := weatherCodeToCondition(data.Daily.WeatherCode[i], true)
+
+		dayDisplay := dateStr
+		if t, err := time.Parse("2006-01-02", dateStr); err == nil {
+			dayDisplay = t.Format("Mon")
+		}
+
+		precipProbMax := 0
+		if i < len(data.Daily.PrecipProbMax) {
+			precipProbMax = data.Daily.PrecipProbMax[i]
+		}
+
+		daily = append(daily, DailyForecast{
+			Date:           dateStr,
+			Day:            dayDisplay,
+			High:           data.Daily.Temperature2mMax[i],
+			Low:            data.Daily.Temperature2mMin[i],
+			WeatherCode:    data.Daily.WeatherCode[i],
+			Condition:      dayCondition,
+			ConditionEmoji: dayEmoji,
+			PrecipProbMax:  precipProbMax,
+		})
+	}
+
+	if len(hourly) == 0 {
+		// Current conditions are still worth serving; the page says the
+		// hourly forecast is missing.
+		slog.WarnContext(ctx, "forecast has no hourly data", "lat", q.Lat, "lon", q.Lon)
+	}
+	weather.PrecipProbNow = s.precipProbAt(data.Current.Time, hourly)
+	weather.ClothingAdvice = clothingAdvice(weather)
+	low, high := temperatureRange(hourly, 24)
+
+	return &Forecast{
+		Current:        weather,
+		Hourly:         hourly,
+		Daily:          daily,
+		PrecipTotal24h: sumFirst(data.Hourly.Precipitation, 24),
+		TodayHigh:      high,
+		TodayLow:       low,
+		raw:            &data,
+	}, nil
+}
+
+// precipProbAt returns the precipitation probability of the hourly entry
+// covering the observation time current, or 0 if there isn't one.
+func (s *Server) precipProbAt(current string, hourly []HourlyForecast) int {
+	t, err := parseLocalTime(current, s.timezone)
+	if err != nil {
+		return 0
+	}
+	hour := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	for _, h := range hourly {
+		if ht, err := parseLocalTime(h.Time, s.timezone); err == nil && ht.Equal(hour) {
+			return h.PrecipProb
+		}
+	}
+	return 0
+}
+
+// upcomingHours drops the leading hourly entries that are before the
+// current hour in the forecast timezone, so the forecast starts now even
+// when it was fetched a while ago. Entries are assumed to be in time order.
+// The returned slice shares hourly's backing array.
+func (s *Server) upcomingHours(hourly []HourlyForecast) []HourlyForecast {
+	now := s.Now().In(s.timezone)
+	currentHour := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, s.timezone)
+	for i, h := range hourly {
+		t, err := parseLocalTime(h.Time, s.timezone)
+		if err != nil || !t.Before(currentHour) {
+			return hourly[i:]
+		}
+	}
+	return hourly[len(hourly):]
+}
+
+// rainChanceToday returns the highest hourly chance of precipitation from
+// the current hour until midnight in loc, reporting false if hourly has
+// none of those hours.
+func rainChanceToday(hourly []HourlyForecast, now time.Time, loc *time.Location) (int, bool) {
+	now = now.In(loc)
+	currentHour := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, loc)
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
+	chance, ok := 0, false
+	for _, h := range hourly {
+		t, err := parseLocalTime(h.Time, loc)
+		if err != nil || t.Before(currentHour) || !t.Before(midnight) {
+			continue
+		}
+		chance, ok = max(chance, h.PrecipProb), true
+	}
+	return chance, ok
+}
+
+// maxHourlyStep is the largest ?step= accepted, which leaves one entry per
+// day.
+const maxHourlyStep = 24
+
+// parseHourlyStep interprets the ?step= query parameter, defaulting to 1.
+func parseHourlyStep(v string) (int, error) {
+	if v == "" {
+		return 1, nil
+	}
+	step, err := strconv.Atoi(v)
+	if err != nil || step < 1 || step > maxHourlyStep {
+		return 0, fmt.Errorf("step must be a whole number from 1 to %d", maxHourlyStep)
+	}
+	return step, nil
+}
+
+// everyNthHour returns every step'th entry of hourly, starting with the
+// first. hourly itself is left alone, since it may be held by the cache.
+func everyNthHour(hourly []HourlyForecast, step int) []HourlyForecast {
+	if step <= 1 {
+		return hourly
+	}
+	thinned := make([]HourlyForecast, 0, (len(hourly)+step-1)/step)
+	for i := 0; i < len(hourly); i += step {
+		thinned = append(thinned, hourly[i])
+	}
+	return thinned
+}
+
+// valueAt returns values[i], or the zero value when values is too short.
+func valueAt[T any](values []T, i int) T {
+	if i < len(values) {
+		return values[i]
+	}
+	var zero T
+	return zero
+}
+
+// sumFirst returns the sum of the first n values, or of all of them if
+// there are fewer than n.
+func sumFirst(values []float64, n int) float64 {
+	var total float64
+	for _, v := range values[:min(n, len(values))] {
+		total += v
+	}
+	return total
+}
+
+// temperatureRange returns the lowest and highest temperatures in the
+// first n hourly entries, or nils if there are none.
+func temperatureRange(hourly []HourlyForecast, n int) (low, high *float64) {
+	hourly = hourly[:min(n, len(hourly))]
+	if len(hourly) == 0 {
+		return nil, nil
+	}
+	lo, hi := hourly[0].Temperature, hourly[0].Temperature
+	for _, h := range hourly[1:] {
+		lo, hi = min(lo, h.Temperature), max(hi, h.Temperature)
+	}
+	return &lo, &hi
+}
+
+// parseLocalTime parses an Open-Meteo timestamp into loc. Times are local
+// wall-clock values without an offset, with or without seconds, but
+// ISO8601 values with an offset are accepted and converted.
+func parseLocalTime(v string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z07:00"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.In(loc), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", v)
+}
+
+// relativeTime describes the Open-Meteo timestamp v relative to now, like
+// "3 minutes ago". Times less than a minute either side of now are "just
+// now", and later ones, from a clock running behind upstream's, are "in 5
+// minutes". A timestamp that doesn't parse is returned as is.
+func relativeTime(v string, now time.Time, loc *time.Location) string {
+	t, err := parseLocalTime(v, loc)
+	if err != nil {
+		return v
+	}
+	d := now.Sub(t)
+	format := "%s ago"
+	if d < 0 {
+		d = -d
+		format = "in %s"
+	}
+	var n int
+	var unit string
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		n, unit = int(d/time.Minute), "minute"
+	case d < 24*time.Hour:
+		n, unit = int(d/time.Hour), "hour"
+	default:
+		n, unit = int(d/(24*time.Hour)), "day"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf(format, fmt.Sprintf("%d %s", n, unit))
+}
+
+// formatClock renders an Open-Meteo timestamp as a local time like "6:42 AM",
+// or returns it unchanged if it can't be parsed.
+func (s *Server) formatClock(v string) string {
+	t, err := parseLocalTime(v, s.timezone)
+	if err != nil {
+		return v
+	}
+	return t.Format("3:04 PM")
+}
+
+// formatHour renders an hourly forecast timestamp as a local hour like
+// "3 PM". A value that can't be parsed is logged and returned unchanged.
+func (s *Server) formatHour(v string) string {
+	t, err := parseLocalTime(v, s.timezone)
+	if err != nil {
+		slog.Warn("parse hourly forecast time", "value", v, "error", err)
+		return v
+	}
+	return t.Format("3 PM")
+}
+
+// getWithRetry GETs url, retrying network errors and 5xx responses up to
+// MaxRetries times with delays from s.retryDelay. 4xx responses are
+// returned as-is, and a cancelled ctx stops the loop immediately.
+func (s *Server) getWithRetry(ctx context.Context, url string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("build request: %w", err)
+		}
+		if s.UserAgent != "" {
+			req.Header.Set("User-Agent", s.UserAgent)
+		}
+		slog.DebugContext(ctx, "upstream request", "url", url, "attempt", attempt+1)
+		resp, err := s.HTTPClient.Do(req)
+		retryable := err != nil || resp.StatusCode >= 500
+		if !retryable || attempt >= s.MaxRetries || ctx.Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+
+		delay := s.retryDelay(attempt)
+		slog.WarnContext(ctx, "retrying weather fetch", "attempt", attempt+1, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isSnowCode reports whether a WMO weather code describes falling snow.
+func isSnowCode(code int) bool {
+	switch code {
+	case 71, 73, 75, 77, 85, 86:
+		return true
+	}
+	return false
+}
+
+// isRainCode reports whether a WMO weather code describes falling rain
+// or drizzle, including showers and thunderstorms.
+func isRainCode(code int) bool {
+	switch {
+	case code >= 51 && code <= 67, code >= 80 && code <= 82, code >= 95 && code <= 99:
+		return true
+	}
+	return false
+}
+
+// weatherCondition is how a WMO weather code is shown: a stable condition
+// key, translated for display with translateCondition, and its emoji by
+// day and by night.
+type weatherCondition struct {
+	Condition  string `json:"condition"`
+	DayEmoji   string `json:"day_emoji"`
+	NightEmoji string `json:"night_emoji"`
+}
+
+// weatherConditions covers every code in Open-Meteo's subset of the WMO
+// interpretation table (WW); the intensity variants within a group
+// (slight, moderate, heavy) share a condition. Besides the page and the
+// API responses, it is served as is by /api/conditions.
+var weatherConditions = map[int]weatherCondition{
+	0:  {"clear_sky", "☀️", "🌙"},
+	1:  {"mainly_clear", "🌤️", "🌙"},
+	2:  {"partly_cloudy", "⛅", "⛅"},
+	3:  {"overcast", "☁️", "☁️"},
+	45: {"fog", "🌫️", "🌫️"},
+	48: {"fog", "🌫️", "🌫️"},
+	51: {"drizzle", "🌧️", "🌧️"},
+	53: {"drizzle", "🌧️", "🌧️"},
+	55: {"drizzle", "🌧️", "🌧️"},
+	56: {"freezing_drizzle", "🌧️❄️", "🌧️❄️"},
+	57: {"freezing_drizzle", "🌧️❄️", "🌧️❄️"},
+	61: {"rain", "🌧️", "🌧️"},
+	63: {"rain", "🌧️", "🌧️"},
+	65: {"rain", "🌧️", "🌧️"},
+	66: {"freezing_rain", "🌧️❄️", "🌧️❄️"},
+	67: {"freezing_rain", "🌧️❄️", "🌧️❄️"},
+	71: {"snow", "🌨️", "🌨️"},
+	73: {"snow", "🌨️", "🌨️"},
+	75: {"snow", "🌨️", "🌨️"},
+	77: {"snow_grains", "🌨️", "🌨️"},
+	80: {"rain_showers", "🌦️", "🌦️"},
+	81: {"rain_showers", "🌦️", "🌦️"},
+	82: {"rain_showers", "🌦️", "🌦️"},
+	85: {"snow_showers", "🌨️", "🌨️"},
+	86: {"snow_showers", "🌨️", "🌨️"},
+	95: {"thunderstorm", "⛈️", "⛈️"},
+	96: {"thunderstorm_hail", "⛈️", "⛈️"},
+	99: {"thunderstorm_hail", "⛈️", "⛈️"},
+}
+
+// weatherCodeToCondition returns the condition key and emoji for a WMO
+// weather code, from weatherConditions.
+func weatherCodeToCondition(code int, isDay bool) (string, string) {
+	c, ok := weatherConditions[code]
+	if !ok {
+		// Open-Meteo only emits the codes above; log anything else so a
+		// newly added code gets noticed and mapped.
+		slog.Debug("unknown weather code", "code", code)
+		return "unknown", "❓"
+	}
+	if isDay {
+		return c.Condition, c.DayEmoji
+	}
+	return c.Condition, c.NightEmoji
+}
+
+// feelsLikeThreshold is how far, in degrees of either unit, the apparent
+// temperature must differ from the actual one to feel warmer or colder.
+const feelsLikeThreshold = 2.0
+
+// feelsLikeLabel describes an apparent-minus-actual temperature difference.
+func feelsLikeLabel(delta float64) string {
+	switch {
+	case delta >= feelsLikeThreshold:
+		return "feels warmer"
+	case delta <= -feelsLikeThreshold:
+		return "feels colder"
+	default:
+		return "feels about right"
+	}
+}
+
+// The NWS wind chill is defined at or below 50°F with winds of at least
+// 3 mph; the heat index is only meaningful from 80°F.
+const (
+	windChillMaxF   = 50.0
+	windChillMinMph = 3.0
+	heatIndexMinF   = 80.0
+)
+
+// windChill returns the NWS wind chill temperature, in °F.
+func windChill(tempF, windMph float64) float64 {
+	v := math.Pow(windMph, 0.16)
+	return 35.74 + 0.6215*tempF - 35.75*v + 0.4275*tempF*v
+}
+
+// heatIndex returns the NWS heat index, in °F: the Rothfusz regression
+// with its low and high humidity adjustments, or Steadman's simpler
+// formula where that gives under 80°F.
+func heatIndex(tempF float64, humidity int) float64 {
+	t, rh := tempF, float64(humidity)
+	simple := 0.5 * (t + 61 + (t-68)*1.2 + rh*0.094)
+	if (simple+t)/2 < 80 {
+		return simple
+	}
+	hi := -42.379 + 2.04901523*t + 10.14333127*rh - 0.22475541*t*rh -
+		0.00683783*t*t - 0.05481717*rh*rh + 0.00122874*t*t*rh +
+		0.00085282*t*rh*rh - 0.00000199*t*t*rh*rh
+	switch {
+	case rh < 13 && t >= 80 && t <= 112:
+		hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(t-95))/17)
+	case rh > 85 && t >= 80 && t <= 87:
+		hi += (rh - 85) / 10 * (87 - t) / 5
+	}
+	return hi
+}
+
+// comfortIndexes returns whichever of the wind chill and heat index
+// applies to the conditions, given and returned in units, rounded to 0.1.
+// Both are nil in mild weather.
+func comfortIndexes(units UnitSystem, temp, wind float64, humidity int) (chill, heat *float64) {
+	tempF := units.fahrenheit(temp)
+	round := func(f float64) *float64 {
+		v := math.Round(units.fromFahrenheit(f)*10) / 10
+		return &v
+	}
+	switch windMph := units.mph(wind); {
+	case tempF <= windChillMaxF && windMph >= windChillMinMph:
+		chill = round(windChill(tempF, windMph))
+	case tempF >= heatIndexMinF:
+		heat = round(heatIndex(tempF, humidity))
+	}
+	return chill, heat
+}
+
+// Clothing advice thresholds. Temperatures are what it feels like, in °F.
+const (
+	bundleUpMaxF      = 32.0
+	warmCoatMaxF      = 50.0
+	lightJacketMaxF   = 65.0
+	dressLightMinF    = 80.0
+	windyMinMph       = 20.0
+	umbrellaMinChance = 50
+)
+
+// clothingAdvice suggests what to wear for the current conditions: a
+// layer for how warm it feels, then a windproof layer when it is windy
+// and not hot, then an umbrella or boots when rain or snow is falling or
+// likely this hour.
+func clothingAdvice(w *WeatherData) string {
+	units := w.Units.system()
+	feelsF := units.fahrenheit(w.FeelsLike)
+	var advice []string
+	switch {
+	case feelsF < bundleUpMaxF:
+		advice = append(advice, "Bundle up")
+	case feelsF < warmCoatMaxF:
+		advice = append(advice, "Wear a warm coat")
+	case feelsF < lightJacketMaxF:
+		advice = append(advice, "Light jacket")
+	case feelsF < dressLightMinF:
+		advice = append(advice, "T-shirt weather")
+	default:
+		advice = append(advice, "Dress light")
+	}
+	if units.mph(w.WindSpeed) >= windyMinMph && feelsF < dressLightMinF {
+		advice = append(advice, "Add a windproof layer")
+	}
+	switch {
+	case isSnowCode(w.WeatherCode):
+		advice = append(advice, "Wear boots")
+	case isRainCode(w.WeatherCode), w.Precipitation > 0, w.PrecipProbNow >= umbrellaMinChance:
+		advice = append(advice, "Bring an umbrella")
+	}
+	return strings.Join(advice, ". ") + "."
+}
+
+// Temperature trends compare the current temperature with the average of
+// the next trendHours hours, in degrees of either unit.
+const (
+	trendHours     = 3
+	trendThreshold = 1.0
+)
+
+// tempTrend classifies where the temperature is heading as "rising",
+// "falling" or "steady", given the hourly entries after the current hour.
+// It returns "" when there are none.
+func tempTrend(current float64, next []HourlyForecast) string {
+	next = next[:min(len(next), trendHours)]
+	if len(next) == 0 {
+		return ""
+	}
+	var sum float64
+	for _, h := range next {
+		sum += h.Temperature
+	}
+	switch avg := sum / float64(len(next)); {
+	case avg-current >= trendThreshold:
+		return "rising"
+	case current-avg >= trendThreshold:
+		return "falling"
+	default:
+		return "steady"
+	}
+}
+
+// uvRiskLabel returns the WHO exposure category for a UV index.
+func uvRiskLabel(uv float64) string {
+	switch {
+	case uv < 3:
+		return "Low"
+	case uv < 6:
+		return "Moderate"
+	case uv < 8:
+		return "High"
+	case uv < 11:
+		return "Very High"
+	default:
+		return "Extreme"
+	}
+}
+
+// compassPoints are the 16 compass directions clockwise from north.
+var compassPoints = [...]string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+
+// windDirectionToCompass maps a bearing in degrees to a 16-point compass
+// direction. Bearings outside 0..359 are normalized first, so 360 is "N".
+// It is called from templates, so it must not allocate.
+func windDirectionToCompass(degrees int) string {
+	degrees = (degrees%360 + 360) % 360
+	index := int(float64(degrees)/22.5+0.5) % len(compassPoints)
+	return compassPoints[index]
+}
@@ -0,0 +1,1259 @@
+package srv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"srv.exe.dev/db/dbgen"
+)
+
+func TestWeatherCodeToCondition(t *testing.T) {
+	t.Run("clear day is the sun", func(t *testing.T) {
+		_, emoji := weatherCodeToCondition(0, true)
+		r, size := utf8.DecodeRuneInString(emoji)
+		if r != '\u2600' {
+			t.Errorf("expected first rune U+2600, got %U", r)
+		}
+		// The only thing allowed after the sun is the emoji presentation selector.
+		if rest := emoji[size:]; rest != "" && rest != "\ufe0f" {
+			t.Errorf("unexpected trailing runes %q after sun", rest)
+		}
+	})
+
+	t.Run("every Open-Meteo code is mapped", func(t *testing.T) {
+		// The WMO codes Open-Meteo documents for weather_code.
+		codes := []int{0, 1, 2, 3, 45, 48, 51, 53, 55, 56, 57, 61, 63, 65, 66, 67,
+			71, 73, 75, 77, 80, 81, 82, 85, 86, 95, 96, 99}
+		for _, code := range codes {
+			for _, isDay := range []bool{true, false} {
+				condition, emoji := weatherCodeToCondition(code, isDay)
+				if condition == "unknown" || emoji == "❓" {
+					t.Errorf("code %d (day=%v) falls through to unknown", code, isDay)
+				}
+				if _, ok := conditionTranslations[defaultLanguage][condition]; !ok {
+					t.Errorf("code %d: condition %q has no English text", code, condition)
+				}
+			}
+		}
+	})
+
+	t.Run("unmapped code is logged", func(t *testing.T) {
+		var logs bytes.Buffer
+		prev := slog.Default()
+		slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+		t.Cleanup(func() { slog.SetDefault(prev) })
+
+		if condition, _ := weatherCodeToCondition(42, true); condition != "unknown" {
+			t.Errorf("expected code 42 to be unknown, got %q", condition)
+		}
+		if !strings.Contains(logs.String(), "unknown weather code") || !strings.Contains(logs.String(), "code=42") {
+			t.Errorf("expected a debug log for code 42, got %q", logs.String())
+		}
+	})
+
+	t.Run("all emoji are valid UTF-8", func(t *testing.T) {
+		for code := 0; code <= 99; code++ {
+			for _, isDay := range []bool{true, false} {
+				condition, emoji := weatherCodeToCondition(code, isDay)
+				if !utf8.ValidString(emoji) {
+					t.Errorf("code %d (day=%v): emoji %q is not valid UTF-8", code, isDay, emoji)
+				}
+				// Mojibake shows up as Latin-1 supplement runes like 'â' and 'ð'.
+				for _, r := range emoji {
+					if r >= 0x80 && r <= 0xFF {
+						t.Errorf("code %d (day=%v): emoji %q contains Latin-1 rune %U", code, isDay, emoji, r)
+					}
+				}
+				if !utf8.ValidString(condition) {
+					t.Errorf("code %d (day=%v): condition %q is not valid UTF-8", code, isDay, condition)
+				}
+			}
+		}
+	})
+}
+
+func TestFetchWeatherCanceled(t *testing.T) {
+	started := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+	server, _ := newStubServer(t, stubForecastJSON)
+	server.ForecastURL = upstream.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	_, err := server.fetchWeather(ctx, server.defaultQuery(Imperial))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected error wrapping context.Canceled, got %v", err)
+	}
+}
+
+func TestRefreshForecastSharesFetch(t *testing.T) {
+	var hits atomic.Int64
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-release
+		w.Write([]byte(stubForecastJSON))
+	}))
+	defer upstream.Close()
+	server, _ := newStubServer(t, stubForecastJSON, WithForecastURL(upstream.URL))
+	q := server.defaultQuery(Imperial)
+
+	const callers = 50
+	var wg sync.WaitGroup
+	forecasts := make([]*Forecast, callers)
+	for i := range callers {
+		wg.Go(func() {
+			forecast, err := server.refreshForecast(context.Background(), q)
+			if err != nil {
+				t.Errorf("caller %d: %v", i, err)
+			}
+			forecasts[i] = forecast
+		})
+	}
+	// Give every caller time to join the in-flight fetch.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := hits.Load(); n != 1 {
+		t.Errorf("expected 1 upstream request for %d concurrent refreshes, got %d", callers, n)
+	}
+	for i, forecast := range forecasts {
+		if forecast == nil || forecast != forecasts[0] {
+			t.Fatalf("caller %d got forecast %p, expected the shared %p", i, forecast, forecasts[0])
+		}
+	}
+}
+
+func TestRefreshForecastOutlivesCanceledCaller(t *testing.T) {
+	var hits atomic.Int64
+	started := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			close(started)
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(stubForecastJSON))
+	}))
+	defer upstream.Close()
+	server, _ := newStubServer(t, stubForecastJSON, WithForecastURL(upstream.URL))
+	q := server.defaultQuery(Imperial)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderDone := make(chan error, 1)
+	go func() {
+		_, err := server.refreshForecast(ctx, q)
+		leaderDone <- err
+	}()
+	<-started
+	followerDone := make(chan error, 1)
+	go func() {
+		_, err := server.refreshForecast(context.Background(), q)
+		followerDone <- err
+	}()
+	// Let the follower join the flight before its leader gives up.
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	if err := <-leaderDone; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the canceled caller to get context.Canceled, got %v", err)
+	}
+	if err := <-followerDone; err != nil {
+		t.Errorf("expected the other caller to get a forecast, got %v", err)
+	}
+}
+
+func TestFetchWeatherRecordsObservation(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := server.fetchWeather(ctx, server.defaultQuery(Imperial)); err != nil {
+			t.Fatalf("fetch %d: %v", i, err)
+		}
+	}
+
+	observations, err := server.Store.ListObservations(ctx, dbgen.ListObservationsParams{Limit: 10})
+	if err != nil {
+		t.Fatalf("list observations: %v", err)
+	}
+	if len(observations) != 1 {
+		t.Fatalf("expected 1 observation (second fetch was cached), got %d", len(observations))
+	}
+	if observations[0].Temperature != 41.3 {
+		t.Errorf("expected recorded temperature 41.3, got %v", observations[0].Temperature)
+	}
+
+	t.Run("db failure is not fatal", func(t *testing.T) {
+		server.Store.Close()
+		forecast, err := server.fetchWeather(ctx, server.defaultQuery(Metric))
+		if err != nil {
+			t.Fatalf("expected fetch to succeed despite db failure, got %v", err)
+		}
+		if forecast.Current == nil {
+			t.Fatal("expected weather data")
+		}
+	})
+}
+
+func TestFetchWeatherDaily(t *testing.T) {
+	t.Run("parses days", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON)
+		forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+		if err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		if len(forecast.Daily) != 3 {
+			t.Fatalf("expected 3 days, got %d", len(forecast.Daily))
+		}
+		day := forecast.Daily[1]
+		if day.Day != "Thu" || day.High != 38.2 || day.Low != 29.9 || day.Condition != "snow" || day.PrecipProbMax != 80 {
+			t.Errorf("unexpected day: %+v", day)
+		}
+	})
+
+	t.Run("mismatched lengths", func(t *testing.T) {
+		body := `{"daily": {
+		  "time": ["2025-01-15", "2025-01-16", "2025-01-17"],
+		  "temperature_2m_max": [43.1, 38.2, 45.0],
+		  "temperature_2m_min": [31.4, 29.9],
+		  "weather_code": [3, 71, 0]
+		}}`
+		server, _ := newStubServer(t, body)
+		forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+		if err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		if len(forecast.Daily) != 2 {
+			t.Errorf("expected 2 complete days, got %d", len(forecast.Daily))
+		}
+		if forecast.Daily[0].PrecipProbMax != 0 {
+			t.Errorf("expected missing precipitation probability to default to 0, got %d", forecast.Daily[0].PrecipProbMax)
+		}
+	})
+}
+
+func TestFetchWeatherRetries(t *testing.T) {
+	// flakyUpstream fails the first failures requests with status, then
+	// serves the stub forecast.
+	flakyUpstream := func(t *testing.T, failures int64, status int) (*Server, *atomic.Int64) {
+		var hits atomic.Int64
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if hits.Add(1) <= failures {
+				w.WriteHeader(status)
+				return
+			}
+			w.Write([]byte(stubForecastJSON))
+		}))
+		t.Cleanup(upstream.Close)
+		server, _ := newStubServer(t, stubForecastJSON)
+		server.ForecastURL = upstream.URL
+		server.RetryPolicy = func(int) time.Duration { return time.Millisecond }
+		return server, &hits
+	}
+
+	t.Run("recovers from 5xx", func(t *testing.T) {
+		server, hits := flakyUpstream(t, 2, http.StatusBadGateway)
+		forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+		if err != nil {
+			t.Fatalf("expected retries to succeed, got %v", err)
+		}
+		if forecast.Current.Temperature != 41.3 {
+			t.Errorf("expected temperature 41.3, got %v", forecast.Current.Temperature)
+		}
+		if n := hits.Load(); n != 3 {
+			t.Errorf("expected 3 upstream requests, got %d", n)
+		}
+	})
+
+	t.Run("gives up after last retry", func(t *testing.T) {
+		server, hits := flakyUpstream(t, 100, http.StatusInternalServerError)
+		if _, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial)); err == nil {
+			t.Fatal("expected error after exhausting retries")
+		}
+		if n := hits.Load(); n != 4 {
+			t.Errorf("expected 4 upstream requests, got %d", n)
+		}
+	})
+
+	t.Run("does not retry 4xx", func(t *testing.T) {
+		server, hits := flakyUpstream(t, 1, http.StatusBadRequest)
+		if _, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial)); err == nil {
+			t.Fatal("expected error for 400 response")
+		}
+		if n := hits.Load(); n != 1 {
+			t.Errorf("expected 1 upstream request, got %d", n)
+		}
+	})
+
+	t.Run("cancel stops retrying", func(t *testing.T) {
+		server, hits := flakyUpstream(t, 100, http.StatusServiceUnavailable)
+		server.RetryPolicy = func(int) time.Duration { return time.Hour }
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := server.fetchWeather(ctx, server.defaultQuery(Imperial))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline error, got %v", err)
+		}
+		if n := hits.Load(); n != 1 {
+			t.Errorf("expected 1 upstream request, got %d", n)
+		}
+	})
+}
+
+func TestUpstreamUserAgent(t *testing.T) {
+	var got atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.Header.Get("User-Agent"))
+		w.Write([]byte(stubForecastJSON))
+	}))
+	t.Cleanup(upstream.Close)
+
+	server, _ := newStubServer(t, stubForecastJSON, WithForecastURL(upstream.URL))
+	if _, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial)); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if ua := got.Load().(string); !strings.HasPrefix(ua, "srv.exe.dev-weather/") {
+		t.Errorf("expected the default User-Agent, got %q", ua)
+	}
+
+	server, _ = newStubServer(t, stubForecastJSON, WithForecastURL(upstream.URL), WithUserAgent("weather-test/1.2 (ops@example.com)"))
+	if _, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial)); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if ua := got.Load().(string); ua != "weather-test/1.2 (ops@example.com)" {
+		t.Errorf("expected the configured User-Agent, got %q", ua)
+	}
+}
+
+func TestWindDirectionToCompass(t *testing.T) {
+	tests := []struct {
+		degrees  int
+		expected string
+	}{
+		{0, "N"},
+		{11, "N"},
+		{12, "NNE"},
+		{22, "NNE"},
+		{23, "NNE"},
+		{45, "NE"},
+		{90, "E"},
+		{180, "S"},
+		{270, "W"},
+		{348, "NNW"},
+		{349, "N"},
+		{359, "N"},
+		{360, "N"},
+		{450, "E"},
+		{-90, "W"},
+	}
+	for _, test := range tests {
+		if got := windDirectionToCompass(test.degrees); got != test.expected {
+			t.Errorf("windDirectionToCompass(%d) = %q, expected %q", test.degrees, got, test.expected)
+		}
+	}
+}
+
+func TestWindDirectionToCompassAllocs(t *testing.T) {
+	if n := testing.AllocsPerRun(100, func() { windDirectionToCompass(225) }); n != 0 {
+		t.Errorf("expected no allocations, got %v per call", n)
+	}
+}
+
+func BenchmarkWindDirectionToCompass(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; b.Loop(); i++ {
+		windDirectionToCompass(i % 360)
+	}
+}
+
+// countingTransport counts requests before handing them to the default transport.
+type countingTransport struct {
+	requests atomic.Int64
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestFetchWeatherUsesInjectedClient(t *testing.T) {
+	transport := &countingTransport{}
+	server, hits := newStubServer(t, stubForecastJSON, WithHTTPClient(&http.Client{Transport: transport}))
+
+	if _, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial)); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	// One forecast and one air-quality request.
+	if n := transport.requests.Load(); n != 2 {
+		t.Errorf("expected 2 requests through injected client, got %d", n)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("expected 1 upstream request, got %d", n)
+	}
+}
+
+func TestFetchWeatherSunriseSunset(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if forecast.Current.Sunrise != "7:18 AM" {
+		t.Errorf("expected sunrise 7:18 AM, got %q", forecast.Current.Sunrise)
+	}
+	if forecast.Current.Sunset != "4:51 PM" {
+		t.Errorf("expected sunset 4:51 PM, got %q", forecast.Current.Sunset)
+	}
+}
+
+func TestFetchWeatherUVIndex(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if forecast.Current.UVIndex != 1.45 || forecast.Current.UVRisk != "Low" {
+		t.Errorf("expected UV 1.45 (Low), got %v (%s)", forecast.Current.UVIndex, forecast.Current.UVRisk)
+	}
+}
+
+func TestFormatClock(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"2025-06-21T05:25", "5:25 AM"},
+		{"2025-06-21T20:31:00", "8:31 PM"},
+		{"2025-06-21T09:25:00Z", "5:25 AM"},
+		{"2025-06-21T05:25:00-04:00", "5:25 AM"},
+		{"not a time", "not a time"},
+	}
+	for _, test := range tests {
+		if got := server.formatClock(test.input); got != test.expected {
+			t.Errorf("formatClock(%q) = %q, expected %q", test.input, got, test.expected)
+		}
+	}
+}
+
+func TestFormatHour(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	logs := captureLogs(t)
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"2025-01-15T14:00", "2 PM"},
+		{"2025-01-15T14:00:00", "2 PM"},
+		{"2025-01-15T19:00Z", "2 PM"},
+		{"2025-01-15T14:00-05:00", "2 PM"},
+		{"2025-01-15T19:00:00Z", "2 PM"},
+		{"2025-07-15T14:00:00-04:00", "2 PM"},
+	}
+	for _, test := range tests {
+		if got := server.formatHour(test.input); got != test.expected {
+			t.Errorf("formatHour(%q) = %q, expected %q", test.input, got, test.expected)
+		}
+	}
+	if logs.Len() != 0 {
+		t.Errorf("expected no warnings for valid times, got %s", logs)
+	}
+
+	if got := server.formatHour("tomorrow"); got != "tomorrow" {
+		t.Errorf("formatHour(%q) = %q, expected it unchanged", "tomorrow", got)
+	}
+	if !strings.Contains(logs.String(), `"value":"tomorrow"`) {
+		t.Errorf("expected a warning naming the bad value, got %s", logs)
+	}
+}
+
+func TestUpcomingHours(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	hourly := []HourlyForecast{
+		{Time: "2025-01-15T13:00"},
+		{Time: "2025-01-15T14:00"},
+		{Time: "2025-01-15T15:00"},
+		{Time: "2025-01-15T16:00"},
+	}
+	tests := []struct {
+		name  string
+		now   time.Time
+		first string
+		count int
+	}{
+		{"start of hour", time.Date(2025, 1, 15, 14, 0, 0, 0, server.timezone), "2025-01-15T14:00", 3},
+		{"partway through hour", time.Date(2025, 1, 15, 14, 59, 0, 0, server.timezone), "2025-01-15T14:00", 3},
+		{"clock in UTC", time.Date(2025, 1, 15, 20, 30, 0, 0, time.UTC), "2025-01-15T15:00", 2},
+		{"before forecast", time.Date(2025, 1, 15, 9, 0, 0, 0, server.timezone), "2025-01-15T13:00", 4},
+		{"after forecast", time.Date(2025, 1, 16, 9, 0, 0, 0, server.timezone), "", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server.Now = func() time.Time { return test.now }
+			got := server.upcomingHours(hourly)
+			if len(got) != test.count {
+				t.Fatalf("expected %d entries, got %d: %+v", test.count, len(got), got)
+			}
+			if test.count > 0 && got[0].Time != test.first {
+				t.Errorf("expected first entry %s, got %s", test.first, got[0].Time)
+			}
+		})
+	}
+
+	t.Run("API", func(t *testing.T) {
+		server.Now = func() time.Time { return time.Date(2025, 1, 15, 15, 10, 0, 0, server.timezone) }
+		w := httptest.NewRecorder()
+		server.HandleAPI(w, httptest.NewRequest(http.MethodGet, "/api/weather", nil))
+		var response struct {
+			Hourly []HourlyForecast `json:"hourly"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		if len(response.Hourly) != 2 || response.Hourly[0].Hour != "3 PM" {
+			t.Errorf("expected hourly forecast to start at 3 PM, got %+v", response.Hourly)
+		}
+	})
+}
+
+func TestFeelsLike(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if forecast.Current.FeelsLikeDelta != -5.7 || forecast.Current.FeelsLikeLabel != "feels colder" {
+		t.Errorf("expected -5.7 (feels colder), got %v (%s)", forecast.Current.FeelsLikeDelta, forecast.Current.FeelsLikeLabel)
+	}
+
+	w := httptest.NewRecorder()
+	server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(w.Body.String(), "-6° · feels colder") {
+		t.Errorf("expected the feels-like delta on the page, got %s", w.Body.String())
+	}
+
+	tests := []struct {
+		delta    float64
+		expected string
+	}{
+		{5, "feels warmer"},
+		{2, "feels warmer"},
+		{1.9, "feels about right"},
+		{0, "feels about right"},
+		{-1.9, "feels about right"},
+		{-2, "feels colder"},
+	}
+	for _, test := range tests {
+		if got := feelsLikeLabel(test.delta); got != test.expected {
+			t.Errorf("feelsLikeLabel(%v) = %q, expected %q", test.delta, got, test.expected)
+		}
+	}
+}
+
+func TestWindChill(t *testing.T) {
+	// Values from the NWS wind chill chart.
+	tests := []struct {
+		tempF, windMph, want float64
+	}{
+		{40, 5, 36},
+		{30, 10, 21},
+		{0, 15, -19},
+		{-10, 20, -35},
+		{20, 60, -4},
+	}
+	for _, test := range tests {
+		if got := windChill(test.tempF, test.windMph); math.Abs(got-test.want) > 0.5 {
+			t.Errorf("windChill(%v, %v) = %.1f, expected %v", test.tempF, test.windMph, got, test.want)
+		}
+	}
+}
+
+func TestHeatIndex(t *testing.T) {
+	// Values from the NWS heat index chart.
+	tests := []struct {
+		tempF    float64
+		humidity int
+		want     float64
+	}{
+		{80, 40, 80},
+		{90, 50, 95},
+		{100, 40, 109},
+		{96, 65, 121},
+		{86, 90, 105},
+		{104, 10, 98},
+	}
+	for _, test := range tests {
+		if got := heatIndex(test.tempF, test.humidity); math.Abs(got-test.want) > 1 {
+			t.Errorf("heatIndex(%v, %d) = %.1f, expected %v", test.tempF, test.humidity, got, test.want)
+		}
+	}
+}
+
+func TestComfortIndexes(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if c := forecast.Current; c.WindChill == nil || *c.WindChill != 35.5 || c.HeatIndex != nil {
+		t.Errorf("expected a 35.5°F wind chill for 41.3°F and 9.4 mph, got %v, %v", c.WindChill, c.HeatIndex)
+	}
+
+	value := func(p *float64) string {
+		if p == nil {
+			return "nil"
+		}
+		return strconv.FormatFloat(*p, 'f', 1, 64)
+	}
+	tests := []struct {
+		name        string
+		units       UnitSystem
+		temp, wind  float64
+		humidity    int
+		chill, heat string
+	}{
+		{"cold and windy", Imperial, 30, 10, 50, "21.2", "nil"},
+		{"cold and calm", Imperial, 30, 2, 50, "nil", "nil"},
+		{"mild", Imperial, 65, 20, 50, "nil", "nil"},
+		{"hot", Imperial, 90, 5, 50, "nil", "94.6"},
+		{"metric cold", Metric, -1.1, 16.1, 50, "-6.0", "nil"},
+		{"metric hot", Metric, 32.2, 5, 50, "nil", "34.7"},
+	}
+	for _, test := range tests {
+		chill, heat := comfortIndexes(test.units, test.temp, test.wind, test.humidity)
+		if value(chill) != test.chill || value(heat) != test.heat {
+			t.Errorf("%s: got wind chill %s, heat index %s; expected %s, %s", test.name, value(chill), value(heat), test.chill, test.heat)
+		}
+	}
+}
+
+func TestClothingAdvice(t *testing.T) {
+	tests := []struct {
+		name      string
+		units     UnitSystem
+		feelsLike float64
+		wind      float64
+		code      int
+		precip    float64
+		chance    int
+		want      string
+	}{
+		{"freezing", Imperial, 20, 5, 0, 0, 0, "Bundle up."},
+		{"cold", Imperial, 40, 5, 3, 0, 0, "Wear a warm coat."},
+		{"cool", Imperial, 60, 5, 1, 0, 0, "Light jacket."},
+		{"mild", Imperial, 72, 5, 0, 0, 0, "T-shirt weather."},
+		{"hot", Imperial, 90, 5, 0, 0, 0, "Dress light."},
+		{"cool and rainy", Imperial, 60, 5, 61, 0, 0, "Light jacket. Bring an umbrella."},
+		{"hot thunderstorm", Imperial, 88, 5, 95, 0.2, 80, "Dress light. Bring an umbrella."},
+		{"rain likely", Imperial, 72, 5, 3, 0, 50, "T-shirt weather. Bring an umbrella."},
+		{"drizzle falling", Imperial, 72, 5, 3, 0.01, 0, "T-shirt weather. Bring an umbrella."},
+		{"cold and windy", Imperial, 40, 25, 3, 0, 0, "Wear a warm coat. Add a windproof layer."},
+		{"hot and windy", Imperial, 90, 25, 0, 0, 0, "Dress light."},
+		{"blizzard", Imperial, 10, 30, 75, 0.3, 90, "Bundle up. Add a windproof layer. Wear boots."},
+		{"metric cool", Metric, 15, 10, 0, 0, 0, "Light jacket."},
+		{"metric windy", Metric, 5, 35, 80, 1.2, 60, "Wear a warm coat. Add a windproof layer. Bring an umbrella."},
+	}
+	for _, test := range tests {
+		w := &WeatherData{
+			FeelsLike:     test.feelsLike,
+			WindSpeed:     test.wind,
+			WeatherCode:   test.code,
+			Precipitation: test.precip,
+			PrecipProbNow: test.chance,
+			Units:         test.units.labels(),
+		}
+		if got := clothingAdvice(w); got != test.want {
+			t.Errorf("%s: got %q, expected %q", test.name, got, test.want)
+		}
+	}
+
+	server, _ := newStubServer(t, stubForecastJSON)
+	body := renderRoot(t, server, "/").Body.String()
+	if !strings.Contains(body, `<div class="clothing-advice">👕 Wear a warm coat.</div>`) {
+		t.Errorf("expected clothing advice on the page, got %s", body)
+	}
+}
+
+func TestUVRiskLabel(t *testing.T) {
+	tests := []struct {
+		uv       float64
+		expected string
+	}{
+		{0, "Low"},
+		{2.9, "Low"},
+		{3, "Moderate"},
+		{5.9, "Moderate"},
+		{6, "High"},
+		{7.9, "High"},
+		{8, "Very High"},
+		{10.9, "Very High"},
+		{11, "Extreme"},
+		{14.2, "Extreme"},
+	}
+	for _, test := range tests {
+		if got := uvRiskLabel(test.uv); got != test.expected {
+			t.Errorf("uvRiskLabel(%v) = %q, expected %q", test.uv, got, test.expected)
+		}
+	}
+}
+
+func TestUpstreamError(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":true,"reason":"Too many requests"}`))
+	}))
+	defer upstream.Close()
+	server, _ := newStubServer(t, stubForecastJSON, WithForecastURL(upstream.URL))
+
+	_, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) {
+		t.Fatalf("expected *UpstreamError, got %T: %v", err, err)
+	}
+	if upstreamErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %d", upstreamErr.StatusCode)
+	}
+	if upstreamErr.Body != `{"error":true,"reason":"Too many requests"}` {
+		t.Errorf("unexpected body snippet %q", upstreamErr.Body)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/weather", nil)
+	w := httptest.NewRecorder()
+	server.HandleAPI(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected API to pass through 429, got %d", w.Code)
+	}
+}
+
+func TestUpstreamResponseTooLarge(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A body that keeps going: one big string, well past the limit.
+		io.WriteString(w, `{"current": {"time": "`)
+		chunk := strings.Repeat("x", 64<<10)
+		for range 4 * maxUpstreamBody / len(chunk) {
+			if _, err := io.WriteString(w, chunk); err != nil {
+				return
+			}
+		}
+		io.WriteString(w, `"}}`)
+	}))
+	defer upstream.Close()
+	server, _ := newStubServer(t, stubForecastJSON, WithForecastURL(upstream.URL))
+
+	_, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+	if !errors.Is(err, errUpstreamTooLarge) {
+		t.Fatalf("expected errUpstreamTooLarge, got %v", err)
+	}
+
+	if err := decodeUpstream(strings.NewReader(stubForecastJSON), new(openMeteoResponse)); err != nil {
+		t.Errorf("expected a normal response to decode, got %v", err)
+	}
+	exact := `"` + strings.Repeat("x", maxUpstreamBody-2) + `"`
+	if err := decodeUpstream(strings.NewReader(exact), new(string)); err != nil {
+		t.Errorf("expected a body of exactly the limit to decode, got %v", err)
+	}
+}
+
+func TestFetchWeatherWindGust(t *testing.T) {
+	t.Run("absent defaults to zero", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON)
+		forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+		if err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		if forecast.Current.WindGust != 0 {
+			t.Errorf("expected gust 0 when omitted, got %v", forecast.Current.WindGust)
+		}
+	})
+
+	t.Run("present", func(t *testing.T) {
+		server, _ := newStubServer(t, `{"current": {"wind_speed_10m": 9.4, "wind_gusts_10m": 21.7}}`)
+		forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+		if err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		if forecast.Current.WindGust != 21.7 {
+			t.Errorf("expected gust 21.7, got %v", forecast.Current.WindGust)
+		}
+
+		w := httptest.NewRecorder()
+		server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if !strings.Contains(w.Body.String(), "Gusts 22 mph") {
+			t.Errorf("expected gusts on page, got body: %s", w.Body.String())
+		}
+	})
+}
+
+func TestFetchWeatherPrecipTotal(t *testing.T) {
+	t.Run("sums the first 24 hours", func(t *testing.T) {
+		precip := make([]string, 30)
+		for i := range precip {
+			precip[i] = "0.1"
+		}
+		body := `{"hourly": {"precipitation": [` + strings.Join(precip, ",") + `]}}`
+		server, _ := newStubServer(t, body)
+		forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+		if err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		if math.Abs(forecast.PrecipTotal24h-2.4) > 1e-9 {
+			t.Errorf("expected 2.4, got %v", forecast.PrecipTotal24h)
+		}
+	})
+
+	t.Run("short array", func(t *testing.T) {
+		server, _ := newStubServer(t, `{"hourly": {"precipitation": [0.5, 1.25]}}`)
+		forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Metric))
+		if err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		if forecast.PrecipTotal24h != 1.75 {
+			t.Errorf("expected 1.75, got %v", forecast.PrecipTotal24h)
+		}
+	})
+}
+
+func TestFetchWeatherSnow(t *testing.T) {
+	t.Run("hidden without snow", func(t *testing.T) {
+		server, _ := newStubServer(t, stubForecastJSON)
+		w := httptest.NewRecorder()
+		server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if strings.Contains(w.Body.String(), "on the ground") {
+			t.Errorf("expected no snow card, got body: %s", w.Body.String())
+		}
+	})
+
+	t.Run("present", func(t *testing.T) {
+		body := `{"current": {"weather_code": 3, "snowfall": 1.2, "snow_depth": 0.5}, "current_units": {"snow_depth": "ft"}}`
+		server, _ := newStubServer(t, body)
+		forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+		if err != nil {
+			t.Fatalf("fetch: %v", err)
+		}
+		if forecast.Current.Snowfall != 1.2 {
+			t.Errorf("expected snowfall 1.2, got %v", forecast.Current.Snowfall)
+		}
+		if math.Abs(forecast.Current.SnowDepth-6) > 1e-9 {
+			t.Errorf("expected snow depth 6 in, got %v", forecast.Current.SnowDepth)
+		}
+
+		w := httptest.NewRecorder()
+		server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if !strings.Contains(w.Body.String(), "1.2 in") || !strings.Contains(w.Body.String(), "6 in on the ground") {
+			t.Errorf("expected snow card, got body: %s", w.Body.String())
+		}
+	})
+
+	t.Run("snowing with nothing settled", func(t *testing.T) {
+		server, _ := newStubServer(t, `{"current": {"weather_code": 71}}`)
+		w := httptest.NewRecorder()
+		server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if !strings.Contains(w.Body.String(), "0 in on the ground") {
+			t.Errorf("expected snow card while snowing, got body: %s", w.Body.String())
+		}
+	})
+}
+
+func TestFetchWeatherPrecipProbNow(t *testing.T) {
+	body := `{
+  "current": {"time": "2025-01-15T14:15"},
+  "hourly": {
+    "time": ["2025-01-15T12:00", "2025-01-15T13:00", "2025-01-15T14:00", "2025-01-15T15:00"],
+    "temperature_2m": [40, 41, 42, 43],
+    "weather_code": [3, 3, 61, 61],
+    "precipitation_probability": [5, 10, 35, 60]
+  }
+}`
+	server, _ := newStubServer(t, body)
+	forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if forecast.Current.PrecipProbNow != 35 {
+		t.Errorf("expected the 14:00 probability 35, got %d", forecast.Current.PrecipProbNow)
+	}
+
+	w := httptest.NewRecorder()
+	server.HandleAPICurrent(w, httptest.NewRequest(http.MethodGet, "/api/weather/current", nil))
+	var current struct{ PrecipProbNow int }
+	if err := json.Unmarshal(w.Body.Bytes(), &current); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if current.PrecipProbNow != 35 {
+		t.Errorf("expected PrecipProbNow 35 in API output, got %d", current.PrecipProbNow)
+	}
+
+	w = httptest.NewRecorder()
+	server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(w.Body.String(), "35% chance this hour") {
+		t.Errorf("expected the chance on the page, got body: %s", w.Body.String())
+	}
+}
+
+func TestFetchWeatherMisalignedHourly(t *testing.T) {
+	body := `{
+  "current": {"time": "2025-01-15T14:00"},
+  "hourly": {
+    "time": ["2025-01-15T14:00", "2025-01-15T15:00", "", "2025-01-15T16:00"],
+    "temperature_2m": [40, 41],
+    "weather_code": [3, 61, 61],
+    "precipitation_probability": [5],
+    "is_day": [1, 1, 1, 0]
+  }
+}`
+	server, _ := newStubServer(t, body)
+	forecast, err := server.fetchWeather(context.Background(), server.defaultQuery(Imperial))
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	want := []HourlyForecast{
+		{Time: "2025-01-15T14:00", Temperature: 40, WeatherCode: 3, PrecipProb: 5, IsDay: true},
+		{Time: "2025-01-15T15:00", Temperature: 41, WeatherCode: 61, IsDay: true},
+		{Time: "2025-01-15T16:00", WeatherCode: 0, IsDay: false},
+	}
+	if len(forecast.Hourly) != len(want) {
+		t.Fatalf("expected %d entries, skipping only the one without a time, got %+v", len(want), forecast.Hourly)
+	}
+	for i, w := range want {
+		h := forecast.Hourly[i]
+		if h.Time != w.Time || h.Temperature != w.Temperature || h.WeatherCode != w.WeatherCode || h.PrecipProb != w.PrecipProb || h.IsDay != w.IsDay {
+			t.Errorf("entry %d: expected %+v, got %+v", i, w, h)
+		}
+	}
+	if forecast.Hourly[2].ConditionEmoji == "" {
+		t.Error("expected an emoji for the defaulted weather code")
+	}
+}
+
+func TestPrecipProbAt(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+	hourly := []HourlyForecast{{Time: "2025-01-15T14:00", PrecipProb: 20}}
+	for _, test := range []struct {
+		current string
+		want    int
+	}{
+		{"2025-01-15T14:00", 20},
+		{"2025-01-15T14:59", 20},
+		{"2025-01-15T15:00", 0},
+		{"garbage", 0},
+	} {
+		if got := server.precipProbAt(test.current, hourly); got != test.want {
+			t.Errorf("precipProbAt(%q) = %d, want %d", test.current, got, test.want)
+		}
+	}
+}
+
+func TestRainChanceToday(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hourly := []HourlyForecast{
+		{Time: "2025-01-15T13:00", PrecipProb: 90}, // already past
+		{Time: "2025-01-15T14:00", PrecipProb: 20},
+		{Time: "2025-01-15T18:00", PrecipProb: 55},
+		{Time: "2025-01-15T23:00", PrecipProb: 30},
+		{Time: "2025-01-16T00:00", PrecipProb: 100}, // tomorrow
+		{Time: "not a time", PrecipProb: 100},
+	}
+
+	now := time.Date(2025, 1, 15, 14, 20, 0, 0, ny)
+	if chance, ok := rainChanceToday(hourly, now, ny); !ok || chance != 55 {
+		t.Errorf("expected 55%%, got %d%% (%v)", chance, ok)
+	}
+	// The same instant in UTC is still the 15th in New York.
+	if chance, ok := rainChanceToday(hourly, now.UTC(), ny); !ok || chance != 55 {
+		t.Errorf("expected the day boundary in the forecast timezone, got %d%% (%v)", chance, ok)
+	}
+	late := time.Date(2025, 1, 15, 23, 30, 0, 0, ny)
+	if chance, ok := rainChanceToday(hourly, late, ny); !ok || chance != 30 {
+		t.Errorf("expected only the last hour of the day, got %d%% (%v)", chance, ok)
+	}
+	if _, ok := rainChanceToday(hourly[4:], now, ny); ok {
+		t.Error("expected no chance without any of today's hours")
+	}
+
+	server, _ := newStubServer(t, stubForecastJSON)
+	w := httptest.NewRecorder()
+	server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(w.Body.String(), "40% chance of precipitation today") {
+		t.Errorf("expected today's chance of precipitation on the page, got %s", w.Body.String())
+	}
+}
+
+func TestTempTrend(t *testing.T) {
+	hours := func(temps ...float64) []HourlyForecast {
+		hourly := make([]HourlyForecast, len(temps))
+		for i, temp := range temps {
+			hourly[i].Temperature = temp
+		}
+		return hourly
+	}
+	for _, test := range []struct {
+		name    string
+		current float64
+		next    []HourlyForecast
+		want    string
+	}{
+		{"rising", 40, hours(41, 42, 43), "rising"},
+		{"falling", 40, hours(39, 38, 37), "falling"},
+		{"steady", 40, hours(40.5, 39.5, 40.2), "steady"},
+		{"just under threshold", 40, hours(40.9, 40.9, 40.9), "steady"},
+		{"only the next three hours count", 40, hours(40, 40, 40, 60), "steady"},
+		{"fewer than three hours", 40, hours(45), "rising"},
+		{"no hours", 40, nil, ""},
+	} {
+		if got := tempTrend(test.current, test.next); got != test.want {
+			t.Errorf("%s: tempTrend = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTempTrendOnPage(t *testing.T) {
+	// After the current 14:00 entry, stubForecastJSON averages 40.35,
+	// within a degree of 41.3.
+	server, _ := newStubServer(t, stubForecastJSON)
+	w := httptest.NewRecorder()
+	server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(w.Body.String(), "Holding steady") {
+		t.Errorf("expected a steady trend on the page, got body: %s", w.Body.String())
+	}
+
+	body := `{
+  "current": {"temperature_2m": 41},
+  "hourly": {
+    "time": ["2025-01-15T14:00", "2025-01-15T15:00", "2025-01-15T16:00"],
+    "temperature_2m": [41, 38, 36],
+    "weather_code": [3, 3, 3]
+  }
+}`
+	server, _ = newStubServer(t, body)
+	w = httptest.NewRecorder()
+	server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(w.Body.String(), "Getting colder") {
+		t.Errorf("expected a falling trend on the page, got body: %s", w.Body.String())
+	}
+}
+
+// benchmarkForecastJSON is a full-size forecast: 24 hours and 7 days.
+func benchmarkForecastJSON() string {
+	start := time.Date(2025, 1, 15, 14, 0, 0, 0, time.UTC)
+	var times, temps, codes, probs, precip, isDay []string
+	for i := range 24 {
+		times = append(times, `"`+start.Add(time.Duration(i)*time.Hour).Format("2006-01-02T15:04")+`"`)
+		temps = append(temps, strconv.Itoa(40+i%5))
+		codes = append(codes, strconv.Itoa([]int{0, 3, 61, 71}[i%4]))
+		probs = append(probs, strconv.Itoa(i*4))
+		precip = append(precip, "0.01")
+		isDay = append(isDay, strconv.Itoa(i%2))
+	}
+	var days, highs, lows, dayCodes, dayProbs, sunrises, sunsets []string
+	for i := range 7 {
+		day := start.AddDate(0, 0, i)
+		days = append(days, `"`+day.Format("2006-01-02")+`"`)
+		highs = append(highs, "45")
+		lows = append(lows, "32")
+		dayCodes = append(dayCodes, "3")
+		dayProbs = append(dayProbs, "20")
+		sunrises = append(sunrises, `"`+day.Format("2006-01-02")+`T07:15"`)
+		sunsets = append(sunsets, `"`+day.Format("2006-01-02")+`T16:50"`)
+	}
+	list := func(v []string) string { return "[" + strings.Join(v, ",") + "]" }
+	return `{"current": {"time": "2025-01-15T14:00", "temperature_2m": 41.3, "weather_code": 3, "is_day": 1},
+  "hourly": {"time": ` + list(times) + `, "temperature_2m": ` + list(temps) + `, "weather_code": ` + list(codes) +
+		`, "precipitation_probability": ` + list(probs) + `, "precipitation": ` + list(precip) + `, "is_day": ` + list(isDay) + `},
+  "daily": {"time": ` + list(days) + `, "temperature_2m_max": ` + list(highs) + `, "temperature_2m_min": ` + list(lows) +
+		`, "weather_code": ` + list(dayCodes) + `, "precipitation_probability_max": ` + list(dayProbs) +
+		`, "sunrise": ` + list(sunrises) + `, "sunset": ` + list(sunsets) + `}}`
+}
+
+func BenchmarkFetchWeather(b *testing.B) {
+	body := []byte(benchmarkForecastJSON())
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	b.Cleanup(upstream.Close)
+	server, err := New(filepath.Join(b.TempDir(), "bench.sqlite3"), "bench-hostname", WithForecastURL(upstream.URL))
+	if err != nil {
+		b.Fatal(err)
+	}
+	q := server.defaultQuery(Imperial)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := server.fetchOpenMeteo(context.Background(), q); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTemperatureRange(t *testing.T) {
+	hourly := make([]HourlyForecast, 30)
+	for i := range hourly {
+		hourly[i].Temperature = float64(40 + i%7)
+	}
+	hourly[3].Temperature = -2.5
+	hourly[25].Temperature = 99 // beyond 24 hours
+
+	low, high := temperatureRange(hourly, 24)
+	if low == nil || high == nil || *low != -2.5 || *high != 46 {
+		t.Errorf("expected -2.5..46, got %v..%v", low, high)
+	}
+	if low, high := temperatureRange(nil, 24); low != nil || high != nil {
+		t.Errorf("expected nils for no hourly data, got %v, %v", low, high)
+	}
+}
+
+func TestTodayRangeShown(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON)
+
+	w := httptest.NewRecorder()
+	server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(w.Body.String(), "H 41° · L 40° next 24h") {
+		t.Errorf("expected the 24-hour range on the page, got body: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	server.HandleAPI(w, httptest.NewRequest(http.MethodGet, "/api/weather", nil))
+	var body struct {
+		TodayHigh *float64 `json:"today_high"`
+		TodayLow  *float64 `json:"today_low"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if body.TodayHigh == nil || *body.TodayHigh != 41.3 || body.TodayLow == nil || *body.TodayLow != 39.9 {
+		t.Errorf("expected today_high 41.3 and today_low 39.9, got %v, %v", body.TodayHigh, body.TodayLow)
+	}
+}
+
+func TestTodayRangeWithoutHourly(t *testing.T) {
+	server, _ := newStubServer(t, `{"current": {"temperature_2m": 50}}`)
+
+	w := httptest.NewRecorder()
+	server.HandleRoot(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if strings.Contains(w.Body.String(), "next 24h") {
+		t.Errorf("expected no range without hourly data, got body: %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	server.HandleAPI(w, httptest.NewRequest(http.MethodGet, "/api/weather", nil))
+	if !strings.Contains(w.Body.String(), `"today_high":null`) || !strings.Contains(w.Body.String(), `"today_low":null`) {
+		t.Errorf("expected null today_high and today_low, got %s", w.Body.String())
+	}
+}
+
+func TestParseHourlyStep(t *testing.T) {
+	for v, want := range map[string]int{"": 1, "1": 1, "3": 3, "24": 24} {
+		if got, err := parseHourlyStep(v); err != nil || got != want {
+			t.Errorf("parseHourlyStep(%q) = %d, %v; want %d", v, got, err, want)
+		}
+	}
+	for _, v := range []string{"0", "-1", "25", "2.5", "three"} {
+		if _, err := parseHourlyStep(v); err == nil {
+			t.Errorf("parseHourlyStep(%q): expected an error", v)
+		}
+	}
+}
+
+func TestEveryNthHour(t *testing.T) {
+	hourly := make([]HourlyForecast, 7)
+	for i := range hourly {
+		hourly[i].Temperature = float64(i)
+	}
+	temps := func(hourly []HourlyForecast) []float64 {
+		var temps []float64
+		for _, h := range hourly {
+			temps = append(temps, h.Temperature)
+		}
+		return temps
+	}
+
+	if got, want := temps(everyNthHour(hourly, 3)), []float64{0, 3, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("step 3 = %v, want %v", got, want)
+	}
+	if got := everyNthHour(hourly, 1); len(got) != 7 {
+		t.Errorf("step 1 = %v, want every hour", temps(got))
+	}
+	if got := everyNthHour(hourly, 24); len(got) != 1 {
+		t.Errorf("step 24 = %v, want only the first hour", temps(got))
+	}
+	if got, want := temps(hourly), []float64{0, 1, 2, 3, 4, 5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("input modified to %v", got)
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	loc, err := time.LoadLocation(defaultTimezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 1, 15, 14, 0, 0, 0, loc)
+	tests := []struct {
+		ago  time.Duration
+		want string
+	}{
+		{0, "just now"},
+		{59 * time.Second, "just now"},
+		{-59 * time.Second, "just now"},
+		{time.Minute, "1 minute ago"},
+		{2 * time.Minute, "2 minutes ago"},
+		{59*time.Minute + 59*time.Second, "59 minutes ago"},
+		{time.Hour, "1 hour ago"},
+		{23*time.Hour + 59*time.Minute, "23 hours ago"},
+		{24 * time.Hour, "1 day ago"},
+		{72 * time.Hour, "3 days ago"},
+		{-5 * time.Minute, "in 5 minutes"},
+		{-2 * time.Hour, "in 2 hours"},
+	}
+	for _, test := range tests {
+		v := now.Add(-test.ago).Format("2006-01-02T15:04:05")
+		if got := relativeTime(v, now, loc); got != test.want {
+			t.Errorf("relativeTime(%s) at %s = %q, want %q", v, now.Format("15:04:05"), got, test.want)
+		}
+	}
+
+	if got := relativeTime("2025-01-15T18:55Z", now, loc); got != "5 minutes ago" {
+		t.Errorf("expected an offset timestamp to be converted, got %q", got)
+	}
+	for _, v := range []string{"", "yesterday"} {
+		if got := relativeTime(v, now, loc); got != v {
+			t.Errorf("relativeTime(%q) = %q, want it returned unchanged", v, got)
+		}
+	}
+}
+
+func TestUpstreamHours(t *testing.T) {
+	server, _ := newStubServer(t, stubForecastJSON, WithForecastHours(36))
+	for hours, want := range map[int]int{1: 0, 24: 0, 36: 0, 37: 48, 48: 48, 49: 72, 167: 168, 168: 168} {
+		if got := server.upstreamHours(hours); got != want {
+			t.Errorf("upstreamHours(%d) = %d, want %d", hours, got, want)
+		}
+	}
+}